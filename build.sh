@@ -5,6 +5,7 @@ platforms=("darwin/amd64" "darwin/arm64" "freebsd/386" "freebsd/amd64" "freebsd/
 package="qcip"
 output="dist"
 version=$1
+pubkey=$MINISIGN_PUBLIC_KEY # self-update 校验签名使用的 minisign 公钥，为空时构建出的程序不能自动更新
 date=`TZ='Asia/Shanghai' date +%Y/%m/%d\ %H:%M:%S`
 
 for platform in "${platforms[@]}"
@@ -12,7 +13,7 @@ do
     goos="${platform%/*}"
     goarch="${platform#*/}"
     echo "Building for $goos/$goarch"
-    GOOS=$goos GOARCH=$goarch CGO_ENABLED=0 go build -o $output/qcip -ldflags "-X main.version=$version -X \"main.buildTime=$date UTC+8\" -s -w"
+    GOOS=$goos GOARCH=$goarch CGO_ENABLED=0 go build -o $output/qcip -ldflags "-X main.version=$version -X \"main.buildTime=$date UTC+8\" -X main.updatePublicKey=$pubkey -s -w"
 
     if [ $goos = "windows" ]; then
         mv $output/qcip $output/qcip.exe
@@ -26,4 +27,19 @@ do
     fi
 done
 cd $output
+sha256sum *.tar.gz *.zip > sha256sums.txt
+# 使用 MINISIGN_SECRET_KEY 指定的私钥签名 sha256sums.txt，生成 sha256sums.txt.minisig
+# 私钥应由 minisign -G -W 生成，不加密；加密的私钥需通过 MINISIGN_PASSWORD 提供密码，从标准输入传给 minisign，避免等待终端输入
+if [ -n "$MINISIGN_SECRET_KEY" ]; then
+    if [ -n "$MINISIGN_PASSWORD" ]; then
+        printf '%s\n' "$MINISIGN_PASSWORD" | minisign -S -s "$MINISIGN_SECRET_KEY" -m sha256sums.txt -t "qcip $version"
+    else
+        minisign -S -s "$MINISIGN_SECRET_KEY" -m sha256sums.txt -t "qcip $version" < /dev/null
+    fi
+fi
+# 写入了公钥的程序只接受签名的发布，没有签名时不能发布
+if [ -n "$pubkey" ] && [ ! -s sha256sums.txt.minisig ]; then
+    echo "MINISIGN_PUBLIC_KEY is set but sha256sums.txt is not signed, check MINISIGN_SECRET_KEY and MINISIGN_PASSWORD" >&2
+    exit 1
+fi
 md5sum * > md5.txt
\ No newline at end of file
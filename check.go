@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// Nagios 插件的返回值
+const (
+	checkOK       = 0
+	checkWarning  = 1
+	checkCritical = 2
+	checkUnknown  = 3
+)
+
+var checkStates = []string{"OK", "WARNING", "CRITICAL", "UNKNOWN"}
+
+var (
+	checkMode   = false     // qcip check，errExit 以 UNKNOWN 结束
+	checkOutput = os.Stdout // 插件的输出位置，其他输出改为写入标准错误
+)
+
+// qcip check，按照 Nagios 插件规范检查规则是否与当前IP一致，不修改任何规则
+func checkCommand() {
+	checkMode = true
+	// 标准输出只保留插件的结果
+	checkOutput = os.Stdout
+	os.Stdout = os.Stderr
+	start := time.Now()
+
+	configData := prepareRun()
+	key := targetKey(configData)
+	cred, err := resolveCredential(configData)
+	if err != nil {
+		finishCheck(checkUnknown, "credential error for "+key+": "+err.Error(), nil, nil)
+	}
+	ip := ipAddr
+	if ip == "" {
+		if ip, err = fetchIPaddr(configData.GetIPAPI, configData.GatewayAddr, int(configData.MaxRetries)); err != nil {
+			finishCheck(checkUnknown, "cannot get the public ip: "+err.Error(), nil, nil)
+		}
+	}
+	if err = checkIPaddr(ip, configData.AllowIPRanges); err != nil {
+		finishCheck(checkUnknown, "ip address check failed: "+err.Error(), nil, nil)
+	}
+	ip = applyIPPrefix(ip, configData.IPPrefix)
+
+	rules, err := fetchFirewallRules(configData, cred)
+	if err != nil {
+		logger.Error("Cannot fetch rules", "target", key, "error", err)
+		finishCheck(checkCritical, "cannot fetch rules of "+key+": "+err.Error(), nil, nil)
+	}
+	changes, missing := matchRules(rules, configData.Rules, ip)
+	state, summary, details := evaluateCheck(key, ip, changes, missing)
+	perfdata := checkPerfdata(changes, missing, time.Since(start))
+	finishCheck(state, summary, perfdata, details)
+}
+
+// 规则有名称未找到时为 CRITICAL，有规则与 ip 不一致时为 WARNING
+func evaluateCheck(key string, ip string, changes []ruleChange, missing []string) (int, string, []string) {
+	var (
+		outOfSync []string
+		details   []string
+	)
+	for _, c := range changes {
+		if !c.inSync() {
+			outOfSync = append(outOfSync, c.Rule.Description+" ("+c.Rule.CIDR+")")
+		}
+	}
+	if len(outOfSync) > 0 {
+		details = append(details, "out of sync: "+strings.Join(outOfSync, ", "))
+	}
+	if len(missing) > 0 {
+		details = append(details, "not found: "+strings.Join(missing, ", "))
+	}
+	switch {
+	case len(missing) > 0:
+		summary := fmt.Sprintf("%d rule name(s) not found on %s", len(missing), key)
+		if len(outOfSync) > 0 {
+			summary += fmt.Sprintf(", %d rule(s) out of sync with %s", len(outOfSync), ip)
+		}
+		return checkCritical, summary, details
+	case len(outOfSync) > 0:
+		return checkWarning, fmt.Sprintf("%d of %d rule(s) on %s out of sync with %s", len(outOfSync), len(changes), key, ip), details
+	}
+	return checkOK, fmt.Sprintf("%d rule(s) on %s in sync with %s", len(changes), key, ip), details
+}
+
+// 性能数据，out_of_sync 大于 0 时为 WARNING，missing 大于 0 时为 CRITICAL
+func checkPerfdata(changes []ruleChange, missing []string, elapsed time.Duration) []string {
+	outOfSync := 0
+	for _, c := range changes {
+		if !c.inSync() {
+			outOfSync++
+		}
+	}
+	return []string{
+		fmt.Sprintf("rules=%d;;;0", len(changes)),
+		fmt.Sprintf("in_sync=%d;;;0", len(changes)-outOfSync),
+		fmt.Sprintf("out_of_sync=%d;0;;0", outOfSync),
+		fmt.Sprintf("missing=%d;;0;0", len(missing)),
+		fmt.Sprintf("time=%.3fs;;;0", elapsed.Seconds()),
+	}
+}
+
+// 输出一行结果和性能数据，其余行为详细信息，然后按照 state 退出
+func finishCheck(state int, summary string, perfdata []string, details []string) {
+	writeCheckResult(checkOutput, state, summary, perfdata, details)
+	os.Exit(state)
+}
+
+func writeCheckResult(w io.Writer, state int, summary string, perfdata []string, details []string) {
+	// | 在 Nagios 中用于分隔性能数据，不能出现在信息中
+	clean := func(s string) string {
+		s = strings.ReplaceAll(redact(s), "|", "/")
+		return strings.Join(strings.Fields(s), " ")
+	}
+	line := "QCIP " + checkStates[state] + " - " + clean(summary)
+	if len(perfdata) > 0 {
+		line += " | " + strings.Join(perfdata, " ")
+	}
+	fmt.Fprintln(w, line)
+	for _, d := range details {
+		fmt.Fprintln(w, clean(d))
+	}
+}
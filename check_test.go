@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEvaluateCheck(t *testing.T) {
+	inSync := ruleChange{Rule: firewallRule{Description: "ssh", CIDR: "203.0.113.7"}, NewCIDR: "203.0.113.7"}
+	outOfSync := ruleChange{Rule: firewallRule{Description: "rdp", CIDR: "198.51.100.1"}, NewCIDR: "203.0.113.7"}
+	tests := []struct {
+		name        string
+		changes     []ruleChange
+		missing     []string
+		wantState   int
+		wantSummary string
+		wantDetails []string
+	}{
+		{"in sync", []ruleChange{inSync}, nil, checkOK, "1 rule(s) on lh/ap-guangzhou/lhins-1 in sync with 203.0.113.7", nil},
+		{"out of sync", []ruleChange{inSync, outOfSync}, nil, checkWarning,
+			"1 of 2 rule(s) on lh/ap-guangzhou/lhins-1 out of sync with 203.0.113.7",
+			[]string{"out of sync: rdp (198.51.100.1)"}},
+		{"missing", []ruleChange{inSync}, []string{"web"}, checkCritical,
+			"1 rule name(s) not found on lh/ap-guangzhou/lhins-1",
+			[]string{"not found: web"}},
+		{"missing and out of sync", []ruleChange{outOfSync}, []string{"web", "db"}, checkCritical,
+			"2 rule name(s) not found on lh/ap-guangzhou/lhins-1, 1 rule(s) out of sync with 203.0.113.7",
+			[]string{"out of sync: rdp (198.51.100.1)", "not found: web, db"}},
+	}
+	for _, tt := range tests {
+		state, summary, details := evaluateCheck("lh/ap-guangzhou/lhins-1", "203.0.113.7", tt.changes, tt.missing)
+		if state != tt.wantState || summary != tt.wantSummary || strings.Join(details, "\n") != strings.Join(tt.wantDetails, "\n") {
+			t.Errorf("%s: got %s %q %q, want %s %q %q", tt.name, checkStates[state], summary, details, checkStates[tt.wantState], tt.wantSummary, tt.wantDetails)
+		}
+	}
+}
+
+func TestCheckPerfdata(t *testing.T) {
+	changes := []ruleChange{
+		{Rule: firewallRule{CIDR: "203.0.113.7"}, NewCIDR: "203.0.113.7"},
+		{Rule: firewallRule{CIDR: "198.51.100.1"}, NewCIDR: "203.0.113.7"},
+	}
+	got := strings.Join(checkPerfdata(changes, []string{"web"}, 1234567*time.Microsecond), " ")
+	want := "rules=2;;;0 in_sync=1;;;0 out_of_sync=1;0;;0 missing=1;;0;0 time=1.235s;;;0"
+	if got != want {
+		t.Fatalf("perfdata %q, want %q", got, want)
+	}
+}
+
+func TestWriteCheckResult(t *testing.T) {
+	var buf bytes.Buffer
+	perfdata := checkPerfdata(nil, nil, 0)
+	writeCheckResult(&buf, checkWarning, "rule a|b\nout of sync", perfdata, []string{"out of sync: a|b (198.51.100.1)", "not found:\tweb"})
+	want := "QCIP WARNING - rule a/b out of sync | rules=0;;;0 in_sync=0;;;0 out_of_sync=0;0;;0 missing=0;;0;0 time=0.000s;;;0\n" +
+		"out of sync: a/b (198.51.100.1)\n" +
+		"not found: web\n"
+	if buf.String() != want {
+		t.Fatalf("got %q, want %q", buf.String(), want)
+	}
+
+	buf.Reset()
+	writeCheckResult(&buf, checkUnknown, "cannot get the public ip", nil, nil)
+	if buf.String() != "QCIP UNKNOWN - cannot get the public ip\n" {
+		t.Fatalf("got %q, want no perfdata separator", buf.String())
+	}
+}
+
+// 参数和配置错误按照插件规范以 UNKNOWN 退出，标准输出只有一行结果
+func TestCheckUnknownExit(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "bad.json"), []byte(`{"Product":"x"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"unknown flag", []string{"check", "--bogus"}, "invalid arguments: unknown flag: --bogus"},
+		{"extra argument", []string{"check", "extra"}, `invalid arguments: unknown command "extra" for "qcip check"`},
+		{"missing config", []string{"check", "-c", "missing.json"}, "Config error: config file missing.json does not exist"},
+		{"invalid config", []string{"check", "-c", "bad.json"}, "Config error: bad.json:1: Product: unknown field"},
+	}
+	line := regexp.MustCompile(`^QCIP UNKNOWN - [^|\n]+\n$`)
+	for _, tt := range tests {
+		stdout, _, code := runQcip(t, dir, nil, tt.args...)
+		if code != checkUnknown {
+			t.Errorf("%s: exit %d, want %d", tt.name, code, checkUnknown)
+		}
+		if !line.MatchString(stdout) || !strings.Contains(stdout, tt.want) {
+			t.Errorf("%s: stdout %q, want one UNKNOWN line with %q", tt.name, stdout, tt.want)
+		}
+	}
+}
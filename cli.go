@@ -0,0 +1,456 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+const jsonAnnotation = "json" // 支持 --output json 的命令
+
+// 执行命令行，返回退出码
+func execute(args []string) int {
+	root := newRootCmd()
+	root.SetArgs(legacyArgs(args))
+	if err := root.Execute(); err != nil {
+		cmd, _, findErr := root.Find(legacyArgs(args))
+		if findErr != nil {
+			cmd = root
+		}
+		// check 的参数错误按照插件规范返回 UNKNOWN
+		if cmd.Name() == "check" {
+			writeCheckResult(checkOutput, checkUnknown, "invalid arguments: "+err.Error(), nil, nil)
+			return checkUnknown
+		}
+		// 参数错误和用法写入标准错误，不影响脚本读取标准输出
+		fmt.Fprintf(os.Stderr, "\033[31mError arguments: %s\033[0m\n%s", redact(err.Error()), cmd.UsageString())
+		return 1
+	}
+	return 0
+}
+
+// 兼容旧版本的 -ip 参数，POSIX 风格下会被解析为 -i -p
+func legacyArgs(args []string) []string {
+	converted := make([]string, len(args))
+	for i, arg := range args {
+		if arg == "-ip" || strings.HasPrefix(arg, "-ip=") {
+			arg = "-" + arg
+		}
+		converted[i] = arg
+	}
+	return converted
+}
+
+// run 及根命令共用的参数
+func addRunFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&ipAddr, "ip", "", "use the given ipv4 address instead of getting it automatically")
+	// 兼容旧版本的 --ipaddr 参数
+	flags.StringVar(&ipAddr, "ipaddr", "", "alias of --ip")
+	flags.MarkHidden("ipaddr")
+	flags.BoolVarP(&forceRun, "force", "f", false, "ignore the cache of the last applied ip and check the rules")
+	flags.BoolVarP(&EnableWinNotify, "winnotify", "n", false, "send notification cards, only available on Windows")
+	flags.DurationVar(&runInterval, "interval", 0, "keep running and check the ip at this interval, e.g. 5m")
+	if goos != "windows" {
+		flags.MarkHidden("winnotify")
+	}
+}
+
+// 校验 --ip --winnotify 和 --interval
+func checkRunFlags() error {
+	if runInterval != 0 && runInterval < minRunInterval {
+		return errors.New("--interval must be at least " + minRunInterval.String())
+	}
+	if EnableWinNotify && goos != "windows" {
+		return errors.New("--winnotify is only available on Windows")
+	}
+	if ipAddr != "" {
+		ip := net.ParseIP(ipAddr)
+		if ip == nil {
+			return errors.New("ip address is incorrect")
+		}
+		if ip.To4() == nil {
+			return errors.New("ip address is not ipv4")
+		}
+	}
+	return nil
+}
+
+func newRootCmd() *cobra.Command {
+	var showVersion bool
+	root := &cobra.Command{
+		Use:   "qcip",
+		Short: "Keep the ip in cloud firewall rules up to date with the current public ip",
+		Long: "qcip updates the source ip of Tencent Cloud Lighthouse, Tencent Cloud security group and\n" +
+			"Alibaba Cloud Lighthouse firewall rules to the current public ip.\n" +
+			"Running qcip without a command is the same as qcip run.",
+		Example: "  qcip                       Run with the first config file found\n" +
+			"  qcip -c qcipconf.json      Run with the config file qcipconf.json\n" +
+			"  qcip --ip 1.1.1.1          Use ip 1.1.1.1 instead of getting it automatically\n" +
+			"  qcip -p office             Run with the profile office\n" +
+			"  qcip plan                  Show what would be changed",
+		Args:          cobra.NoArgs,
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		Annotations:   map[string]string{jsonAnnotation: "true"},
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := setupLogger(); err != nil {
+				return err
+			}
+			switch outputFormat {
+			case "text":
+			case "json":
+				if cmd.Annotations[jsonAnnotation] == "" {
+					return errors.New("--output json is not supported by " + cmd.CommandPath())
+				}
+				// 标准输出只保留 json，其他信息改为写入标准错误
+				jsonOutput = os.Stdout
+				os.Stdout = os.Stderr
+			default:
+				return errors.New("--output must be one of " + strings.Join(outputFormats, ", "))
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if showVersion {
+				for _, name := range []string{"ip", "ipaddr", "force", "winnotify", "interval"} {
+					if cmd.Flags().Changed(name) {
+						return errors.New("--version cannot be used with --" + name)
+					}
+				}
+				showVersionInfo()
+				return nil
+			}
+			if err := checkRunFlags(); err != nil {
+				return err
+			}
+			keyFunc()
+			return nil
+		},
+	}
+	root.PersistentFlags().StringVarP(&confPath, "config", "c", "", "config file, searched in the standard locations when not set")
+	root.PersistentFlags().StringVarP(&profileName, "profile", "p", profileName, "profile in the config file, defaults to $"+profileEnv)
+	root.PersistentFlags().StringVar(&outputFormat, "output", "text", "output format, text or json (run, plan and status only)")
+	root.RegisterFlagCompletionFunc("output", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return outputFormats, cobra.ShellCompDirectiveNoFileComp
+	})
+	root.PersistentFlags().StringVar(&logLevel, "log-level", logLevel, "log level, one of "+strings.Join(logLevels, ", "))
+	root.PersistentFlags().StringVar(&logFormat, "log-format", logFormat, "log format, text or json")
+	root.PersistentFlags().StringVar(&logFile, "log-file", "", "write logs to the file, rotated by --log-max-size")
+	root.PersistentFlags().IntVar(&logMaxSize, "log-max-size", logMaxSize, "rotate the log file when it reaches this size in MB")
+	root.PersistentFlags().IntVar(&logMaxBackups, "log-max-backups", logMaxBackups, "number of rotated log files to keep")
+	root.PersistentFlags().StringSliceVar(&logSinks, "log-sink", nil, "also write logs to stderr, syslog or journald, can be repeated")
+	for name, values := range map[string][]string{"log-level": logLevels, "log-format": logFormats, "log-sink": logSinkSet} {
+		values := values
+		root.RegisterFlagCompletionFunc(name, func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			return values, cobra.ShellCompDirectiveNoFileComp
+		})
+	}
+	root.Flags().BoolVarP(&showVersion, "version", "v", false, "show version information")
+	addRunFlags(root.Flags())
+	root.AddCommand(
+		newRunCmd(),
+		newPlanCmd(),
+		newStatusCmd(),
+		newCheckCmd(),
+		newRulesCmd(),
+		newRollbackCmd(),
+		newDoctorCmd(),
+		newVersionCmd(),
+		newSelfUpdateCmd(),
+		newInitCmd(),
+		newConfigCmd(),
+		newPolicyCmd(),
+	)
+	return root
+}
+
+func newRunCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:         "run",
+		Short:       "Update the firewall rules to the current public ip",
+		Args:        cobra.NoArgs,
+		Annotations: map[string]string{jsonAnnotation: "true"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := checkRunFlags(); err != nil {
+				return err
+			}
+			keyFunc()
+			return nil
+		},
+	}
+	addRunFlags(cmd.Flags())
+	return cmd
+}
+
+func newPlanCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:         "plan",
+		Short:       "Show which rules would be changed without changing anything",
+		Args:        cobra.NoArgs,
+		Annotations: map[string]string{jsonAnnotation: "true"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := checkRunFlags(); err != nil {
+				return err
+			}
+			planCommand()
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&ipAddr, "ip", "", "use the given ipv4 address instead of getting it automatically")
+	return cmd
+}
+
+func newStatusCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:         "status",
+		Short:       "Show the current ip and the state of each matched rule",
+		Args:        cobra.NoArgs,
+		Annotations: map[string]string{jsonAnnotation: "true"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := checkRunFlags(); err != nil {
+				return err
+			}
+			statusCommand()
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&ipAddr, "ip", "", "use the given ipv4 address instead of getting it automatically")
+	return cmd
+}
+
+func newCheckCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "check",
+		Short: "Check the rules as a Nagios/Icinga plugin without modifying them",
+		Long: "Check whether every matched rule allows the current ip, print a one-line status with perfdata\n" +
+			"and exit with 0 (OK), 1 (WARNING, rules out of sync), 2 (CRITICAL, provider unreachable or\n" +
+			"rules not found) or 3 (UNKNOWN, config, credential or ip lookup error).",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := checkRunFlags(); err != nil {
+				return err
+			}
+			checkCommand()
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&ipAddr, "ip", "", "use the given ipv4 address instead of getting it automatically")
+	return cmd
+}
+
+func newRulesCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "rules",
+		Short: "List, export or apply all firewall rules of the target",
+	}
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "Show all firewall rules of the target in a table",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			rulesListCommand()
+		},
+	}
+	var format, output string
+	exportCmd := &cobra.Command{
+		Use:   "export",
+		Short: "Write all firewall rules of the target as json, csv or yaml",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if !containsString(ruleExportFormats, format) {
+				return errors.New("--format must be one of " + strings.Join(ruleExportFormats, ", "))
+			}
+			rulesExportCommand(format, output)
+			return nil
+		},
+	}
+	exportCmd.Flags().StringVar(&format, "format", "json", "output format, one of "+strings.Join(ruleExportFormats, ", "))
+	exportCmd.Flags().StringVarP(&output, "output-file", "o", "", "write to the file instead of stdout")
+	exportCmd.RegisterFlagCompletionFunc("format", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return ruleExportFormats, cobra.ShellCompDirectiveNoFileComp
+	})
+	var (
+		file   string
+		yes    bool
+		dryRun bool
+	)
+	applyCmd := &cobra.Command{
+		Use:   "apply",
+		Short: "Make the firewall rules of the target exactly match a rules file",
+		Long: "Make the firewall rules of the target exactly match a rules file, rules not in the file are deleted.\n" +
+			"The file uses the format of qcip rules export, the cidr " + selfPlaceholder + " is replaced with the current public ip.\n" +
+			"The plan is shown and confirmed before anything is changed.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := checkRunFlags(); err != nil {
+				return err
+			}
+			rulesApplyCommand(file, yes, dryRun)
+			return nil
+		},
+	}
+	applyCmd.Flags().StringVarP(&file, "file", "f", "", "rules file in json or yaml")
+	applyCmd.Flags().BoolVarP(&yes, "yes", "y", false, "apply without asking for confirmation")
+	applyCmd.Flags().BoolVar(&dryRun, "dry-run", false, "only show the plan")
+	applyCmd.Flags().StringVar(&ipAddr, "ip", "", "use the given ipv4 address for "+selfPlaceholder+" instead of getting it automatically")
+	applyCmd.MarkFlagRequired("file")
+	cmd.AddCommand(listCmd, exportCmd, applyCmd)
+	return cmd
+}
+
+func newRollbackCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "rollback",
+		Short: "Restore the rules to the ip they had before the last change",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			rollbackCommand()
+		},
+	}
+}
+
+func newDoctorCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "doctor",
+		Short: "Check the config, ip api, credential and permissions without changing anything",
+		Long: "Check the config, proxy, ip api, credential, read permission and rules without changing anything.\n" +
+			"The modify permission is checked by reading the CAM or RAM policies attached to the key and\n" +
+			"comparing them with qcip policy, which needs cam:ListAttachedUserAllPolicies, cam:ListAttachedRolePolicies\n" +
+			"and cam:GetPolicy, or ram:ListPoliciesForUser, ram:ListGroupsForUser, ram:ListPoliciesForGroup,\n" +
+			"ram:ListPoliciesForRole and ram:GetPolicy. Without them the modify permission is reported as unverified.",
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			doctorCommand()
+		},
+	}
+}
+
+func newVersionCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "version",
+		Short: "Show version information and check for updates",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			showVersionInfo()
+		},
+	}
+	cmd.Flags().StringVar(&updateChannel, "channel", "", "update channel, stable or beta, defaults to $"+updateChannelEnv)
+	cmd.Flags().BoolVar(&noUpdateCheck, "no-check", false, "do not check for updates, also set by $"+noUpdateCheckEnv)
+	cmd.RegisterFlagCompletionFunc("channel", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return updateChannels, cobra.ShellCompDirectiveNoFileComp
+	})
+	return cmd
+}
+
+func newSelfUpdateCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "self-update",
+		Short: "Download, verify and install the latest version",
+		Long: "Download the release archive for this platform, verify its SHA-256 checksum and the minisign\n" +
+			"signature of the checksums with the embedded public key, then replace the running program.\n" +
+			"The previous version is restored if the new one cannot be installed or started.",
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			selfUpdateCommand()
+		},
+	}
+	cmd.Flags().StringVar(&updateChannel, "channel", "", "update channel, stable or beta, defaults to $"+updateChannelEnv)
+	cmd.Flags().BoolVar(&forceUpdate, "force", false, "reinstall even when the latest version is already installed")
+	cmd.RegisterFlagCompletionFunc("channel", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return updateChannels, cobra.ShellCompDirectiveNoFileComp
+	})
+	return cmd
+}
+
+func newInitCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "init [path]",
+		Short: "Create a config file interactively",
+		Args:  cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			output := confPath
+			if len(args) == 1 {
+				output = args[0]
+			}
+			initCommand(output)
+		},
+	}
+}
+
+func newConfigCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Manage the config file",
+	}
+	var showAll bool
+	pathCmd := &cobra.Command{
+		Use:   "path",
+		Short: "Print the config file that would be used",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			configPathCommand(showAll)
+		},
+	}
+	pathCmd.Flags().BoolVarP(&showAll, "all", "a", false, "also show the search order")
+
+	var (
+		output        string
+		recipients    []string
+		recipientFile string
+		identityFile  string
+	)
+	input := func(args []string) string {
+		if len(args) == 1 {
+			return args[0]
+		}
+		return confPath
+	}
+	encryptCmd := &cobra.Command{
+		Use:   "encrypt [path]",
+		Short: "Encrypt the config file with age recipients or a passphrase",
+		Args:  cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			configCryptCommand("encrypt", input(args), output, recipients, recipientFile, "")
+		},
+	}
+	encryptCmd.Flags().StringVarP(&output, "output-file", "o", "", "output path, defaults to <path>"+encryptedExt)
+	encryptCmd.Flags().StringArrayVarP(&recipients, "recipient", "r", nil, "age X25519 recipient, can be repeated")
+	encryptCmd.Flags().StringVarP(&recipientFile, "recipients-file", "R", "", "file containing age recipients")
+
+	decryptCmd := &cobra.Command{
+		Use:   "decrypt [path]",
+		Short: "Decrypt the config file",
+		Args:  cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			configCryptCommand("decrypt", input(args), output, nil, "", identityFile)
+		},
+	}
+	decryptCmd.Flags().StringVarP(&output, "output-file", "o", "", "output path, defaults to <path> without "+encryptedExt)
+	decryptCmd.Flags().StringVarP(&identityFile, "identity", "i", "", "age identity file, defaults to $"+ageIdentityEnv)
+
+	cmd.AddCommand(pathCmd, encryptCmd, decryptCmd)
+	return cmd
+}
+
+func newPolicyCmd() *cobra.Command {
+	var (
+		uin      string
+		withInit bool
+	)
+	cmd := &cobra.Command{
+		Use:   "policy",
+		Short: "Print the least-privilege CAM or RAM policy for the config",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			policyCommand(uin, withInit)
+		},
+	}
+	cmd.Flags().StringVar(&uin, "uin", "", "account id written into the resource")
+	cmd.Flags().BoolVar(&withInit, "init", false, "also allow the actions used by qcip init")
+	return cmd
+}
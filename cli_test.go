@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+const helperArgsEnv = "QCIP_TEST_ARGS"
+
+// 在子进程中执行 qcip，由 runQcip 调用
+func TestQcipHelperProcess(t *testing.T) {
+	args, ok := os.LookupEnv(helperArgsEnv)
+	if !ok {
+		return
+	}
+	var argv []string
+	if args != "" {
+		argv = strings.Split(args, "\n")
+	}
+	os.Exit(execute(argv))
+}
+
+// 在 dir 中以子进程执行 qcip，返回标准输出、标准错误和退出码
+// 子进程不继承配置文件相关的环境变量，env 中的变量会覆盖它们
+func runQcip(t *testing.T, dir string, env []string, args ...string) (string, string, int) {
+	cmd := exec.Command(os.Args[0], "-test.run=^TestQcipHelperProcess$")
+	cmd.Dir = dir
+	for _, e := range os.Environ() {
+		if !strings.HasPrefix(e, configEnv+"=") && !strings.HasPrefix(e, "XDG_CONFIG_HOME=") && !strings.HasPrefix(e, "HOME=") {
+			cmd.Env = append(cmd.Env, e)
+		}
+	}
+	cmd.Env = append(cmd.Env, "HOME="+t.TempDir(), "XDG_CONFIG_HOME="+t.TempDir(), helperArgsEnv+"="+strings.Join(args, "\n"))
+	cmd.Env = append(cmd.Env, env...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		t.Fatal(err)
+	}
+	return stdout.String(), stderr.String(), cmd.ProcessState.ExitCode()
+}
+
+// 旧版本的调用方式应解析为相同的参数
+func TestLegacyArgs(t *testing.T) {
+	tests := []struct {
+		name      string
+		args      []string
+		cmd       string
+		config    string
+		ip        string
+		winnotify bool
+	}{
+		{"bare", nil, "qcip", "", "", false},
+		{"notify and config", []string{"-n", "-c", "x"}, "qcip", "x", "", true},
+		{"long config", []string{"--config=x"}, "qcip", "x", "", false},
+		{"legacy -ip", []string{"-ip", "1.1.1.1"}, "qcip", "", "1.1.1.1", false},
+		{"legacy -ip=", []string{"-ip=1.1.1.1", "-c", "x"}, "qcip", "x", "1.1.1.1", false},
+		{"ipaddr alias", []string{"--ipaddr", "1.1.1.1"}, "qcip", "", "1.1.1.1", false},
+		{"run subcommand", []string{"run", "-ip", "1.1.1.1", "-n"}, "run", "", "1.1.1.1", true},
+		{"config before subcommand", []string{"-c", "x", "run", "--ip=1.1.1.1"}, "run", "x", "1.1.1.1", false},
+	}
+	for _, tt := range tests {
+		root := newRootCmd()
+		args := legacyArgs(tt.args)
+		cmd, rest, err := root.Find(args)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if err = cmd.ParseFlags(rest); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if cmd.Name() != tt.cmd || confPath != tt.config || ipAddr != tt.ip || EnableWinNotify != tt.winnotify {
+			t.Errorf("%s: got command %s, config %q, ip %q, winnotify %v", tt.name, cmd.Name(), confPath, ipAddr, EnableWinNotify)
+		}
+		if len(cmd.Flags().Args()) != 0 {
+			t.Errorf("%s: unexpected arguments %v", tt.name, cmd.Flags().Args())
+		}
+	}
+	confPath, ipAddr, EnableWinNotify = "", "", false
+}
+
+func TestUnknownFlag(t *testing.T) {
+	stdout, stderr, code := runQcip(t, t.TempDir(), nil, "--no-such-flag")
+	if code != 1 {
+		t.Fatalf("exit code %d, want 1", code)
+	}
+	if stdout != "" {
+		t.Errorf("stdout %q, want nothing", stdout)
+	}
+	if !strings.Contains(stderr, "unknown flag: --no-such-flag") || !strings.Contains(stderr, "Usage:\n  qcip [flags]") {
+		t.Errorf("stderr does not contain the error and usage:\n%s", stderr)
+	}
+}
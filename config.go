@@ -0,0 +1,749 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed config.schema.json
+var configSchemaData []byte
+
+type Config struct {
+	MType               string
+	SecretId            string
+	SecretKey           string
+	SessionToken        string
+	CredentialSource    string
+	Profile             string
+	RoleArn             string
+	RoleSessionName     string
+	RoleDuration        Duration
+	RoleName            string
+	MetadataEndpoint    string
+	GetIPAPI            string
+	GatewayAddr         string
+	InstanceId          string
+	InstanceRegion      string
+	SecurityGroupId     string
+	SecurityGroupRegion string
+	IPProxy             string
+	APIProxy            string
+	MaxRetries          compatInt
+	RetryMaxElapsed     Duration
+	EnableWinNotify     bool
+	AllowIPRanges       []string
+	StateFile           string
+	CacheMaxAge         Duration
+	IPPrefix            int
+	Rules               []string
+}
+
+// 整数配置项，兼容旧版本配置文件中 "3" 形式的字符串
+type compatInt int
+
+func (i *compatInt) UnmarshalJSON(data []byte) error {
+	var n int
+	if err := json.Unmarshal(data, &n); err == nil {
+		*i = compatInt(n)
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return errors.New("should be an integer")
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil {
+		return errors.New(strconv.Quote(s) + " is not an integer")
+	}
+	*i = compatInt(n)
+	return nil
+}
+
+// 时长配置项，支持 "24h" "30m" 形式的字符串或整数秒
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var seconds int64
+	if err := json.Unmarshal(data, &seconds); err == nil {
+		*d = Duration(time.Duration(seconds) * time.Second)
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return errors.New("should be a duration like 24h or a number of seconds")
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil || v < 0 {
+		return errors.New(strconv.Quote(s) + " is not a duration like 24h or 30m")
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// 带行号的配置项，Value 为 map[string]*configNode、[]*configNode 或标量
+type configNode struct {
+	Line  int
+	Value interface{}
+}
+
+// 配置文件中的一处错误
+type configError struct {
+	Line    int
+	Path    string
+	Msg     string
+	Warning bool // 仅提示，不影响运行
+}
+
+// 将错误和警告分开
+func splitConfigErrors(errs []configError) (fatal []configError, warnings []configError) {
+	for _, e := range errs {
+		if e.Warning {
+			warnings = append(warnings, e)
+		} else {
+			fatal = append(fatal, e)
+		}
+	}
+	return fatal, warnings
+}
+
+func (e configError) format(file string) string {
+	location := file
+	if e.Line > 0 {
+		location += ":" + strconv.Itoa(e.Line)
+	}
+	if e.Path == "" {
+		return location + ": " + e.Msg
+	}
+	return location + ": " + e.Path + ": " + e.Msg
+}
+
+// 根据扩展名判断配置文件格式
+func configFormat(path string) string {
+	path = strings.TrimSuffix(path, encryptedExt)
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return "yaml"
+	case ".toml":
+		return "toml"
+	}
+	return "json"
+}
+
+// 读取配置文件
+func getConfig(confPath string) Config {
+	configData, errs, err := loadConfig(confPath)
+	if err != nil {
+		errOutput("Config error: " + err.Error())
+		errExit()
+	}
+	errs, warnings := splitConfigErrors(errs)
+	for _, w := range warnings {
+		logger.Warn("Config warning", "path", confPath, "warning", w.format(confPath))
+	}
+	if len(errs) > 0 {
+		errOutput("Config error:")
+		for _, e := range errs {
+			errOutput("  " + e.format(confPath))
+		}
+		errExit()
+	}
+	if configData.EnableWinNotify {
+		EnableWinNotify = true
+	}
+	logger.Info("Config loaded", "path", confPath, "target", targetKey(configData))
+	return configData
+}
+
+// 读取、解密并解析配置文件，err 为无法读取的错误，errs 为配置内容的错误
+func loadConfig(confPath string) (Config, []configError, error) {
+	config, err := os.ReadFile(confPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return Config{}, nil, errors.New("config file " + confPath + " does not exist")
+		}
+		return Config{}, nil, err
+	}
+	if isEncryptedConfig(config) {
+		if config, err = decryptConfig(config, ""); err != nil {
+			return Config{}, nil, errors.New("failed to decrypt " + confPath + "\n  " + err.Error())
+		}
+	}
+	configData, errs := parseConfig(config, configFormat(confPath), profileName, filepath.Dir(confPath))
+	addSecrets(configData.SecretId, configData.SecretKey, configData.SessionToken)
+	return configData, errs, nil
+}
+
+// 解析并校验配置文件内容，baseDir 为配置文件所在的目录
+func parseConfig(data []byte, format string, profile string, baseDir string) (Config, []configError) {
+	var configData Config
+	root, err := parseConfigNode(data, format)
+	if err != nil {
+		return configData, []configError{*err}
+	}
+	errs := validateSchema(root, configSchema(), "")
+	if len(errs) > 0 {
+		sortConfigErrors(errs)
+		return configData, errs
+	}
+	if errs = applyProfile(root, profile); len(errs) > 0 {
+		sortConfigErrors(errs)
+		return configData, errs
+	}
+	configData, errs = decodeConfig(root)
+	if len(errs) > 0 {
+		sortConfigErrors(errs)
+		return configData, errs
+	}
+	if errs = resolveConfigSecrets(&configData, root, baseDir); len(errs) > 0 {
+		sortConfigErrors(errs)
+		return configData, errs
+	}
+	if !root.has("CacheMaxAge") {
+		configData.CacheMaxAge = Duration(defaultCacheMaxAge)
+	}
+	if !root.has("RetryMaxElapsed") {
+		configData.RetryMaxElapsed = Duration(defaultRetryMaxElapsed)
+	}
+	errs = validateConfig(configData, root)
+	sortConfigErrors(errs)
+	return configData, errs
+}
+
+// 严格地将节点树解码为配置，逐个字段解码以便定位出错的行，并拒绝未知字段
+func decodeConfig(root *configNode) (Config, []configError) {
+	var (
+		configData Config
+		errs       []configError
+	)
+	m, ok := root.Value.(map[string]*configNode)
+	if !ok {
+		return configData, []configError{{Line: root.Line, Msg: "config should be an object"}}
+	}
+	v := reflect.ValueOf(&configData).Elem()
+	for key, node := range m {
+		if key == "$schema" {
+			continue
+		}
+		field, ok := v.Type().FieldByName(key)
+		if !ok || field.Name != key {
+			errs = append(errs, configError{Line: node.Line, Path: key, Msg: "unknown field"})
+			continue
+		}
+		raw, _ := json.Marshal(node.plain())
+		decoder := json.NewDecoder(bytes.NewReader(raw))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(v.FieldByIndex(field.Index).Addr().Interface()); err != nil {
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &typeErr) {
+				errs = append(errs, configError{Line: node.Line, Path: key, Msg: "should be " + typeErr.Type.String() + ", got " + typeErr.Value})
+			} else {
+				errs = append(errs, configError{Line: node.Line, Path: key, Msg: err.Error()})
+			}
+		}
+	}
+	return configData, errs
+}
+
+// 将配置文件解析为带行号的节点树
+func parseConfigNode(data []byte, format string) (*configNode, *configError) {
+	switch format {
+	case "toml":
+		var m map[string]interface{}
+		if _, err := toml.Decode(string(data), &m); err != nil {
+			var perr toml.ParseError
+			if errors.As(err, &perr) {
+				return nil, &configError{Line: perr.Position.Line, Msg: "config file is not valid toml: " + perr.Message}
+			}
+			return nil, &configError{Msg: "config file is not valid toml: " + err.Error()}
+		}
+		return tomlToNode(m, string(data)), nil
+	case "json":
+		// 先使用标准库确认是合法的 json，再借助 yaml 解析器获取行号
+		var v interface{}
+		if err := json.Unmarshal(data, &v); err != nil {
+			var serr *json.SyntaxError
+			if errors.As(err, &serr) {
+				return nil, &configError{Line: lineAt(data, serr.Offset), Msg: "config file is not valid json: " + serr.Error()}
+			}
+			return nil, &configError{Msg: "config file is not valid json: " + err.Error()}
+		}
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, &configError{Line: yamlErrorLine(err), Msg: "config file is not valid " + format + ": " + err.Error()}
+	}
+	if doc.Kind == 0 {
+		return nil, &configError{Msg: "config file is empty"}
+	}
+	return yamlToNode(&doc), nil
+}
+
+func yamlToNode(n *yaml.Node) *configNode {
+	switch n.Kind {
+	case yaml.DocumentNode:
+		return yamlToNode(n.Content[0])
+	case yaml.AliasNode:
+		node := yamlToNode(n.Alias)
+		node.Line = n.Line
+		return node
+	case yaml.MappingNode:
+		m := make(map[string]*configNode)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			child := yamlToNode(n.Content[i+1])
+			child.Line = n.Content[i].Line
+			m[n.Content[i].Value] = child
+		}
+		return &configNode{Line: n.Line, Value: m}
+	case yaml.SequenceNode:
+		list := make([]*configNode, 0, len(n.Content))
+		for _, item := range n.Content {
+			list = append(list, yamlToNode(item))
+		}
+		return &configNode{Line: n.Line, Value: list}
+	}
+	var v interface{}
+	_ = n.Decode(&v)
+	return &configNode{Line: n.Line, Value: v}
+}
+
+// toml 解析结果不带行号，通过查找键名所在的行补充
+func tomlToNode(m map[string]interface{}, text string) *configNode {
+	lines := strings.Split(text, "\n")
+	keyLine := func(key string) int {
+		re := regexp.MustCompile(`^\s*["']?` + regexp.QuoteMeta(key) + `["']?\s*=`)
+		for i, l := range lines {
+			if re.MatchString(l) {
+				return i + 1
+			}
+		}
+		return 0
+	}
+	var convert func(v interface{}, line int) *configNode
+	convert = func(v interface{}, line int) *configNode {
+		switch val := v.(type) {
+		case map[string]interface{}:
+			children := make(map[string]*configNode)
+			for k, c := range val {
+				children[k] = convert(c, keyLine(k))
+			}
+			return &configNode{Line: line, Value: children}
+		case []interface{}:
+			list := make([]*configNode, 0, len(val))
+			for _, c := range val {
+				list = append(list, convert(c, line))
+			}
+			return &configNode{Line: line, Value: list}
+		}
+		return &configNode{Line: line, Value: v}
+	}
+	return convert(m, 1)
+}
+
+// 转换为不带行号的普通值
+func (n *configNode) plain() interface{} {
+	switch v := n.Value.(type) {
+	case map[string]*configNode:
+		m := make(map[string]interface{}, len(v))
+		for k, c := range v {
+			m[k] = c.plain()
+		}
+		return m
+	case []*configNode:
+		list := make([]interface{}, 0, len(v))
+		for _, c := range v {
+			list = append(list, c.plain())
+		}
+		return list
+	}
+	return n.Value
+}
+
+// 判断是否存在字段
+func (n *configNode) has(key string) bool {
+	m, ok := n.Value.(map[string]*configNode)
+	if !ok {
+		return false
+	}
+	_, ok = m[key]
+	return ok
+}
+
+// 获取字段所在的行号
+func (n *configNode) lineOf(key string) int {
+	if m, ok := n.Value.(map[string]*configNode); ok {
+		if c, ok := m[key]; ok {
+			return c.Line
+		}
+	}
+	return 0
+}
+
+// 解析内嵌的 JSON Schema
+func configSchema() map[string]interface{} {
+	var schema map[string]interface{}
+	if err := json.Unmarshal(configSchemaData, &schema); err != nil {
+		panic("invalid embedded config schema: " + err.Error())
+	}
+	return schema
+}
+
+// 解析 "#/$defs/duration" 形式的本地引用，"#" 为整个 schema
+func resolveSchemaRef(ref string) map[string]interface{} {
+	if ref == "#" {
+		return configSchema()
+	}
+	var current interface{} = configSchema()
+	for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		current = m[part]
+	}
+	resolved, _ := current.(map[string]interface{})
+	return resolved
+}
+
+// 按照 JSON Schema 校验配置，支持 $ref type enum pattern minimum maximum minLength
+// items minItems uniqueItems properties additionalProperties，以及 ajv-errors 的 errorMessage
+func validateSchema(n *configNode, schema map[string]interface{}, path string) []configError {
+	if ref, ok := schema["$ref"].(string); ok {
+		if resolved := resolveSchemaRef(ref); resolved != nil {
+			schema = resolved
+		}
+	}
+	var errs []configError
+	fail := func(msg string) []configError {
+		// 与 ajv-errors 相同，errorMessage 用于替换默认的错误信息
+		if custom, ok := schema["errorMessage"].(string); ok {
+			msg = custom
+		}
+		return append(errs, configError{Line: n.Line, Path: path, Msg: msg})
+	}
+	if t, ok := schema["type"]; ok {
+		var types []string
+		if s, ok := t.(string); ok {
+			types = []string{s}
+		} else {
+			for _, s := range t.([]interface{}) {
+				types = append(types, s.(string))
+			}
+		}
+		matched := false
+		for _, t := range types {
+			if schemaTypeOf(n.Value, t) {
+				matched = true
+			}
+		}
+		if !matched {
+			return fail("should be " + strings.Join(types, " or ") + ", got " + schemaTypeName(n.Value))
+		}
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		var allowed []string
+		for _, e := range enum {
+			if e == n.Value {
+				found = true
+			}
+			if s, ok := e.(string); ok && s != "" {
+				allowed = append(allowed, s)
+			}
+		}
+		if !found {
+			return fail(fmt.Sprintf("%v is incorrect, it should be one of %s", n.Value, strings.Join(allowed, " ")))
+		}
+	}
+	switch v := n.Value.(type) {
+	case string:
+		if p, ok := schema["pattern"].(string); ok && !regexp.MustCompile(p).MatchString(v) {
+			errs = fail(strconv.Quote(v) + " does not match " + p)
+		}
+		if min, ok := schema["minLength"].(float64); ok && float64(len(v)) < min {
+			errs = fail("is empty")
+		}
+	case []*configNode:
+		if min, ok := schema["minItems"].(float64); ok && float64(len(v)) < min {
+			errs = fail(fmt.Sprintf("should contain at least %v item(s)", min))
+		}
+		if unique, ok := schema["uniqueItems"].(bool); ok && unique {
+			seen := make(map[string]bool)
+			for i, item := range v {
+				key := fmt.Sprint(item.Value)
+				if seen[key] {
+					errs = append(errs, configError{Line: item.Line, Path: path + "[" + strconv.Itoa(i) + "]", Msg: strconv.Quote(key) + " is duplicated"})
+				}
+				seen[key] = true
+			}
+		}
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range v {
+				errs = append(errs, validateSchema(item, items, path+"["+strconv.Itoa(i)+"]")...)
+			}
+		}
+	case map[string]*configNode:
+		props, _ := schema["properties"].(map[string]interface{})
+		for k, c := range v {
+			childPath := k
+			if path != "" {
+				childPath = path + "." + k
+			}
+			if p, ok := props[k].(map[string]interface{}); ok {
+				errs = append(errs, validateSchema(c, p, childPath)...)
+			} else if additional, ok := schema["additionalProperties"].(map[string]interface{}); ok {
+				errs = append(errs, validateSchema(c, additional, childPath)...)
+			} else if additional, ok := schema["additionalProperties"].(bool); ok && !additional {
+				errs = append(errs, configError{Line: c.Line, Path: childPath, Msg: "unknown field"})
+			}
+		}
+	default:
+		if num, ok := toFloat(v); ok {
+			if min, ok := schema["minimum"].(float64); ok && num < min {
+				errs = fail(fmt.Sprintf("should be greater than or equal to %v", min))
+			}
+			if max, ok := schema["maximum"].(float64); ok && num > max {
+				errs = fail(fmt.Sprintf("should be less than or equal to %v", max))
+			}
+		}
+	}
+	return errs
+}
+
+func schemaTypeOf(v interface{}, t string) bool {
+	switch t {
+	case "string":
+		_, ok := v.(string)
+		return ok
+	case "boolean":
+		_, ok := v.(bool)
+		return ok
+	case "array":
+		_, ok := v.([]*configNode)
+		return ok
+	case "object":
+		_, ok := v.(map[string]*configNode)
+		return ok
+	case "null":
+		return v == nil
+	case "number":
+		_, ok := toFloat(v)
+		return ok
+	case "integer":
+		f, ok := toFloat(v)
+		return ok && f == math.Trunc(f)
+	}
+	return false
+}
+
+func schemaTypeName(v interface{}) string {
+	for _, t := range []string{"string", "boolean", "array", "object", "null", "integer", "number"} {
+		if schemaTypeOf(v, t) {
+			return t
+		}
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// 校验 Schema 无法描述的规则
+func validateConfig(configData Config, root *configNode) []configError {
+	var errs []configError
+	add := func(key string, msg string) {
+		errs = append(errs, configError{Line: root.lineOf(key), Path: key, Msg: msg})
+	}
+	warn := func(key string, msg string) {
+		errs = append(errs, configError{Line: root.lineOf(key), Path: key, Msg: msg, Warning: true})
+	}
+	if configData.EnableWinNotify && goos != "windows" {
+		add("EnableWinNotify", "only available on Windows")
+	}
+	// 过短的前缀会放行大量不相关的地址
+	if configData.IPPrefix != 0 && configData.IPPrefix < 24 {
+		warn("IPPrefix", "/"+strconv.Itoa(configData.IPPrefix)+" allows "+strconv.Itoa(1<<(32-configData.IPPrefix))+" addresses, use 24 or longer unless the whole range is trusted")
+	}
+	if configData.MaxRetries < 0 || configData.MaxRetries > 10 {
+		add("MaxRetries", "should be an integer greater than or equal to 0 and less than or equal to 10")
+	}
+	for _, key := range []string{"IPProxy", "APIProxy"} {
+		if err := checkProxySetting(stringField(configData, key)); err != nil {
+			add(key, err.Error())
+		}
+	}
+	for i, r := range configData.AllowIPRanges {
+		if _, err := parseIPRange(r); err != nil {
+			errs = append(errs, configError{Line: root.lineOf("AllowIPRanges"), Path: "AllowIPRanges[" + strconv.Itoa(i) + "]", Msg: r + " is not a valid ip range"})
+		}
+	}
+	for i, r := range configData.Rules {
+		if strings.TrimSpace(r) != r {
+			errs = append(errs, configError{Line: root.lineOf("Rules"), Path: "Rules[" + strconv.Itoa(i) + "]", Msg: strconv.Quote(r) + " has leading or trailing spaces"})
+		}
+	}
+	var (
+		requiredKeys []string
+		idKey        string
+		regionKey    string
+		idPattern    *regexp.Regexp
+		regions      []string
+	)
+	switch configData.CredentialSource {
+	case "", credentialStatic, credentialProfile, credentialMetadata, credentialChain:
+	default:
+		add("CredentialSource", configData.CredentialSource+" is not a valid credential source")
+	}
+	if configData.RoleDuration != 0 && (time.Duration(configData.RoleDuration) < 15*time.Minute || time.Duration(configData.RoleDuration) > 12*time.Hour) {
+		add("RoleDuration", "should be between 15m and 12h")
+	}
+	switch configData.MType {
+	case "lh":
+		requiredKeys = []string{"SecretId", "SecretKey", "InstanceId", "InstanceRegion"}
+		idKey, regionKey, idPattern, regions = "InstanceId", "InstanceRegion", qclhInstanceIdRe, qcRegions
+	case "cvm":
+		requiredKeys = []string{"SecretId", "SecretKey", "SecurityGroupId", "SecurityGroupRegion"}
+		idKey, regionKey, idPattern, regions = "SecurityGroupId", "SecurityGroupRegion", qcSecurityGroupIdRe, qcRegions
+	case "allh":
+		requiredKeys = []string{"SecretId", "SecretKey", "InstanceId", "InstanceRegion"}
+		idKey, regionKey, idPattern, regions = "InstanceId", "InstanceRegion", allhInstanceIdRe, alRegions
+	default:
+		add("MType", "machine type is empty")
+	}
+	if len(configData.Rules) == 0 {
+		add("Rules", "not found")
+	}
+	if configData.CredentialSource != "" && configData.CredentialSource != credentialStatic && len(requiredKeys) > 0 {
+		// 密钥由凭证链在运行时获取
+		requiredKeys = requiredKeys[2:]
+	}
+	missing := make(map[string]bool)
+	for _, key := range requiredKeys {
+		value := stringField(configData, key)
+		if value == "" && !root.has(key) {
+			add(key, "not found")
+		} else if value == "" {
+			add(key, "is empty")
+		} else if value == key {
+			// 仍为模板中的占位内容
+			add(key, "is incorrect")
+		} else {
+			continue
+		}
+		missing[key] = true
+	}
+	if idKey != "" && !missing[idKey] && !idPattern.MatchString(stringField(configData, idKey)) {
+		add(idKey, stringField(configData, idKey)+" is not a valid "+idKey+" for "+configData.MType+", it should look like "+idExample[configData.MType])
+	}
+	// 云服务商会开放新地域，只校验格式，不在已知列表中时仅提示
+	if regionKey != "" && !missing[regionKey] {
+		region := stringField(configData, regionKey)
+		if !regionRe.MatchString(region) {
+			add(regionKey, region+" is not a valid region, it should look like "+regionExample[configData.MType])
+		} else if !containsString(regions, region) {
+			warn(regionKey, region+" is not a known region for "+configData.MType+", please make sure it is correct")
+		}
+	}
+	return errs
+}
+
+var (
+	qclhInstanceIdRe    = regexp.MustCompile(`^lhins-[a-z0-9]{8}$`)
+	qcSecurityGroupIdRe = regexp.MustCompile(`^sg-[a-z0-9]{8}$`)
+	allhInstanceIdRe    = regexp.MustCompile(`^[a-f0-9]{32}$`)
+	regionRe            = regexp.MustCompile(`^[a-z]{2,}(-[a-z0-9]+)+$`)
+	regionExample       = map[string]string{
+		"lh":   "ap-guangzhou",
+		"cvm":  "ap-guangzhou",
+		"allh": "cn-hangzhou",
+	}
+	idExample = map[string]string{
+		"lh":   "lhins-xxxxxxxx",
+		"cvm":  "sg-xxxxxxxx",
+		"allh": "a 32-character hexadecimal id",
+	}
+	// 已知的腾讯云地域
+	qcRegions = []string{
+		"ap-bangkok", "ap-beijing", "ap-chengdu", "ap-chongqing", "ap-guangzhou", "ap-hongkong", "ap-jakarta",
+		"ap-mumbai", "ap-nanjing", "ap-seoul", "ap-shanghai", "ap-singapore", "ap-taipei", "ap-tokyo",
+		"eu-frankfurt", "eu-moscow", "na-ashburn", "na-siliconvalley", "na-toronto", "sa-saopaulo",
+		"ap-beijing-fsi", "ap-shanghai-fsi", "ap-shenzhen-fsi",
+	}
+	// 已知的阿里云轻量应用服务器地域
+	alRegions = []string{
+		"cn-qingdao", "cn-beijing", "cn-zhangjiakou", "cn-huhehaote", "cn-wulanchabu", "cn-hangzhou",
+		"cn-shanghai", "cn-nanjing", "cn-fuzhou", "cn-shenzhen", "cn-heyuan", "cn-guangzhou", "cn-chengdu",
+		"cn-hongkong", "ap-southeast-1", "ap-southeast-2", "ap-southeast-3", "ap-southeast-5", "ap-southeast-6",
+		"ap-southeast-7", "ap-northeast-1", "ap-northeast-2", "ap-south-1", "us-west-1", "us-east-1",
+		"eu-central-1", "eu-west-1", "me-east-1", "me-central-1",
+	}
+)
+
+func stringField(configData Config, key string) string {
+	return reflect.ValueOf(configData).FieldByName(key).String()
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func sortConfigErrors(errs []configError) {
+	sort.SliceStable(errs, func(i, j int) bool {
+		if errs[i].Line != errs[j].Line {
+			return errs[i].Line < errs[j].Line
+		}
+		return errs[i].Path < errs[j].Path
+	})
+}
+
+// 计算字节偏移所在的行号
+func lineAt(data []byte, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return strings.Count(string(data[:offset]), "\n") + 1
+}
+
+var yamlLineRe = regexp.MustCompile(`line (\d+)`)
+
+func yamlErrorLine(err error) int {
+	if m := yamlLineRe.FindStringSubmatch(err.Error()); m != nil {
+		line, _ := strconv.Atoi(m[1])
+		return line
+	}
+	return 0
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseConfigRegion(t *testing.T) {
+	tests := []struct {
+		region  string
+		fatal   bool
+		warning bool
+	}{
+		{"ap-guangzhou", false, false},
+		{"ap-newregion-1", false, true},
+		{"AP_Guangzhou", true, false},
+		{"guangzhou", true, false},
+	}
+	for _, tt := range tests {
+		data := `{"MType":"lh","SecretId":"AKIDtest","SecretKey":"test","InstanceId":"lhins-abcdefgh","InstanceRegion":"` + tt.region + `","Rules":["ssh"]}`
+		_, errs := parseConfig([]byte(data), "json", "", ".")
+		fatal, warnings := splitConfigErrors(errs)
+		if (len(fatal) > 0) != tt.fatal || (len(warnings) > 0) != tt.warning {
+			t.Errorf("%s: got errors %v, warnings %v", tt.region, fatal, warnings)
+		}
+		for _, e := range append(fatal, warnings...) {
+			if e.Path != "InstanceRegion" || !strings.Contains(e.Msg, tt.region) {
+				t.Errorf("%s: unexpected message %s", tt.region, e.format("config.json"))
+			}
+		}
+	}
+}
+
+func TestParseConfigIPPrefix(t *testing.T) {
+	tests := []struct {
+		prefix  string
+		fatal   bool
+		warning bool
+	}{
+		{"32", false, false},
+		{"24", false, false},
+		{"20", false, true},
+		{"16", false, true},
+		{"8", true, false},
+	}
+	for _, tt := range tests {
+		data := `{"MType":"lh","SecretId":"AKIDtest","SecretKey":"test","InstanceId":"lhins-abcdefgh","InstanceRegion":"ap-guangzhou","IPPrefix":` + tt.prefix + `,"Rules":["ssh"]}`
+		_, errs := parseConfig([]byte(data), "json", "", ".")
+		fatal, warnings := splitConfigErrors(errs)
+		if (len(fatal) > 0) != tt.fatal || (len(warnings) > 0) != tt.warning {
+			t.Errorf("IPPrefix %s: got errors %v, warnings %v", tt.prefix, fatal, warnings)
+		}
+	}
+}
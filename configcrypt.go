@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"golang.org/x/term"
+)
+
+const (
+	ageHeader        = "age-encryption.org/v1" // age 文件头
+	encryptedExt     = ".age"                  // 加密配置文件的扩展名
+	passphraseEnv    = "QCIP_PASSPHRASE"       // 口令加密使用的口令
+	ageIdentityEnv   = "QCIP_AGE_IDENTITY"     // X25519 私钥文件路径
+	scryptStanzaMark = "\n-> scrypt "          // 口令加密的文件头标记
+)
+
+// 判断配置文件是否已加密
+func isEncryptedConfig(data []byte) bool {
+	data = bytes.TrimLeft(data, " \t\r\n")
+	return bytes.HasPrefix(data, []byte(ageHeader)) || bytes.HasPrefix(data, []byte(armor.Header))
+}
+
+// 解密配置文件，口令加密时从环境变量读取口令或提示输入，否则使用 identityFile 中的私钥
+func decryptConfig(data []byte, identityFile string) ([]byte, error) {
+	var src io.Reader = bytes.NewReader(bytes.TrimLeft(data, " \t\r\n"))
+	if bytes.HasPrefix(bytes.TrimLeft(data, " \t\r\n"), []byte(armor.Header)) {
+		src = armor.NewReader(src)
+	}
+	raw, err := io.ReadAll(src)
+	if err != nil {
+		return nil, err
+	}
+	var identities []age.Identity
+	if header, _, _ := bytes.Cut(raw, []byte("\n---")); bytes.Contains(header, []byte(scryptStanzaMark)) {
+		passphrase, err := readPassphrase("Enter passphrase for the config file: ", false)
+		if err != nil {
+			return nil, err
+		}
+		identity, err := age.NewScryptIdentity(passphrase)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, identity)
+	} else {
+		if identityFile == "" {
+			identityFile = os.Getenv(ageIdentityEnv)
+		}
+		if identityFile == "" {
+			return nil, errors.New("the config file is encrypted with age recipients, set " + ageIdentityEnv + " to the identity file")
+		}
+		f, err := os.Open(identityFile)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		if identities, err = age.ParseIdentities(f); err != nil {
+			return nil, errors.New("failed to read identity file " + identityFile + ": " + err.Error())
+		}
+	}
+	r, err := age.Decrypt(bytes.NewReader(raw), identities...)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+// 加密配置文件，未指定接收者时使用口令加密，输出为 ASCII 格式以便同步和查看差异
+func encryptConfig(data []byte, recipients []string, recipientFile string) ([]byte, error) {
+	var ageRecipients []age.Recipient
+	for _, r := range recipients {
+		recipient, err := age.ParseX25519Recipient(r)
+		if err != nil {
+			return nil, errors.New("recipient " + r + " is incorrect: " + err.Error())
+		}
+		ageRecipients = append(ageRecipients, recipient)
+	}
+	if recipientFile != "" {
+		f, err := os.Open(recipientFile)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		parsed, err := age.ParseRecipients(f)
+		if err != nil {
+			return nil, errors.New("failed to read recipients file " + recipientFile + ": " + err.Error())
+		}
+		ageRecipients = append(ageRecipients, parsed...)
+	}
+	if len(ageRecipients) == 0 {
+		passphrase, err := readPassphrase("Enter passphrase: ", true)
+		if err != nil {
+			return nil, err
+		}
+		recipient, err := age.NewScryptRecipient(passphrase)
+		if err != nil {
+			return nil, err
+		}
+		ageRecipients = append(ageRecipients, recipient)
+	}
+	var buf bytes.Buffer
+	armorWriter := armor.NewWriter(&buf)
+	w, err := age.Encrypt(armorWriter, ageRecipients...)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(data); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	if err = armorWriter.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// 读取口令，优先使用环境变量，否则在终端中提示输入
+func readPassphrase(prompt string, confirm bool) (string, error) {
+	if passphrase := os.Getenv(passphraseEnv); passphrase != "" {
+		return passphrase, nil
+	}
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", errors.New("no passphrase provided, set " + passphraseEnv + " or run in a terminal")
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	passphrase, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	if len(passphrase) == 0 {
+		return "", errors.New("passphrase is empty")
+	}
+	if confirm {
+		fmt.Fprint(os.Stderr, "Confirm passphrase: ")
+		again, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", err
+		}
+		if !bytes.Equal(passphrase, again) {
+			return "", errors.New("passphrases do not match")
+		}
+	}
+	return string(passphrase), nil
+}
+
+// qcip config encrypt / decrypt，mode 为 encrypt 或 decrypt
+func configCryptCommand(mode string, input string, output string, recipients []string, recipientFile string, identityFile string) {
+	if input == "" {
+		input = findConfig()
+	}
+	data, err := os.ReadFile(input)
+	if err != nil {
+		errOutput("Config error: " + err.Error())
+		errExit()
+	}
+	var result []byte
+	if mode == "encrypt" {
+		if isEncryptedConfig(data) {
+			errOutput("Config error: " + input + " is already encrypted")
+			errExit()
+		}
+		if output == "" {
+			output = input + encryptedExt
+		}
+		result, err = encryptConfig(data, recipients, recipientFile)
+	} else {
+		if !isEncryptedConfig(data) {
+			errOutput("Config error: " + input + " is not encrypted")
+			errExit()
+		}
+		if output == "" {
+			if !strings.HasSuffix(input, encryptedExt) {
+				errOutput("Error arguments: output path not defined, use -o to specify it")
+				errExit()
+			}
+			output = strings.TrimSuffix(input, encryptedExt)
+		}
+		result, err = decryptConfig(data, identityFile)
+	}
+	if err != nil {
+		errOutput("Failed to " + mode + " " + input + ":")
+		errDetail(err)
+		errExit()
+	}
+	if _, err = os.Stat(output); err == nil {
+		errOutput("Error: " + output + " already exists, remove it first or use -o to write elsewhere")
+		errExit()
+	}
+	if err = os.WriteFile(output, result, 0600); err != nil {
+		errOutput("Error: " + err.Error())
+		errExit()
+	}
+	if mode == "encrypt" {
+		fmt.Printf("Encrypted config written to %s\nRemember to delete the plaintext file %s\n", output, input)
+	} else {
+		fmt.Printf("Decrypted config written to %s\n", output)
+	}
+}
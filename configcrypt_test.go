@@ -0,0 +1,103 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+)
+
+const testConfigYAML = `MType: lh
+SecretId: AKIDtest
+SecretKey: test
+InstanceId: lhins-abcdefgh
+InstanceRegion: ap-guangzhou
+Rules:
+  - ssh
+`
+
+func TestConfigCryptPassphrase(t *testing.T) {
+	t.Setenv(passphraseEnv, "correct horse battery staple")
+	encrypted, err := encryptConfig([]byte(testConfigYAML), nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isEncryptedConfig(encrypted) || !strings.Contains(string(encrypted), "BEGIN AGE ENCRYPTED FILE") {
+		t.Fatalf("output is not an armored age file:\n%s", encrypted)
+	}
+	decrypted, err := decryptConfig(encrypted, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decrypted) != testConfigYAML {
+		t.Fatalf("got %q", decrypted)
+	}
+
+	t.Setenv(passphraseEnv, "wrong passphrase")
+	if _, err := decryptConfig(encrypted, ""); err == nil {
+		t.Fatal("decrypted with a wrong passphrase")
+	}
+}
+
+func TestConfigCryptX25519(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	identityFile := filepath.Join(t.TempDir(), "key.txt")
+	if err := os.WriteFile(identityFile, []byte(identity.String()+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := encryptConfig([]byte(testConfigYAML), []string{identity.Recipient().String()}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv(ageIdentityEnv, "")
+	if _, err := decryptConfig(encrypted, ""); err == nil || !strings.Contains(err.Error(), ageIdentityEnv) {
+		t.Fatalf("got %v, want an error naming %s", err, ageIdentityEnv)
+	}
+	t.Setenv(ageIdentityEnv, identityFile)
+	decrypted, err := decryptConfig(encrypted, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decrypted) != testConfigYAML {
+		t.Fatalf("got %q", decrypted)
+	}
+
+	other, _ := age.GenerateX25519Identity()
+	otherFile := filepath.Join(t.TempDir(), "other.txt")
+	os.WriteFile(otherFile, []byte(other.String()+"\n"), 0600)
+	if _, err := decryptConfig(encrypted, otherFile); err == nil {
+		t.Fatal("decrypted with a different identity")
+	}
+}
+
+// config.yaml.age 去掉 .age 后按照 yaml 解析
+func TestLoadEncryptedConfigFormat(t *testing.T) {
+	t.Setenv(passphraseEnv, "test passphrase")
+	encrypted, err := encryptConfig([]byte(testConfigYAML), nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "config.yaml"+encryptedExt)
+	if err := os.WriteFile(path, encrypted, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if got := configFormat(path); got != "yaml" {
+		t.Fatalf("configFormat(%s) = %s, want yaml", path, got)
+	}
+	configData, errs, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fatal, _ := splitConfigErrors(errs); len(fatal) > 0 {
+		t.Fatalf("config errors: %v", fatal)
+	}
+	if configData.InstanceId != "lhins-abcdefgh" || len(configData.Rules) != 1 || configData.Rules[0] != "ssh" {
+		t.Fatalf("got %+v", configData)
+	}
+}
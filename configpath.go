@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const configEnv = "QCIP_CONFIG" // 未使用 -c 时的配置文件路径
+
+// 在配置目录中查找 config.json config.yaml config.yml config.toml 及其加密版本
+var configExts = []string{".json", ".yaml", ".yml", ".toml"}
+
+// 配置文件的查找顺序，-c 和 QCIP_CONFIG 之后依次查找
+// 当前目录的 qcip.json、旧版本默认的 config.json、用户配置目录和 /etc/qcip
+func configCandidates() []string {
+	candidates := []string{"qcip.json", "config.json"}
+	dirs := []string{}
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		dirs = append(dirs, filepath.Join(dir, "qcip"))
+	} else if dir, err := os.UserConfigDir(); err == nil {
+		dirs = append(dirs, filepath.Join(dir, "qcip"))
+	}
+	if goos != "windows" {
+		dirs = append(dirs, "/etc/qcip")
+	}
+	for _, dir := range dirs {
+		for _, ext := range configExts {
+			candidates = append(candidates, filepath.Join(dir, "config"+ext))
+		}
+		for _, ext := range configExts {
+			candidates = append(candidates, filepath.Join(dir, "config"+ext+encryptedExt))
+		}
+	}
+	return candidates
+}
+
+// 确定使用的配置文件及其来源，未找到时 path 为空
+func lookupConfig() (path string, source string) {
+	if confPath != "" {
+		return confPath, "-c"
+	}
+	if env := os.Getenv(configEnv); env != "" {
+		return env, configEnv
+	}
+	for _, c := range configCandidates() {
+		if info, err := os.Stat(c); err == nil && !info.IsDir() {
+			return c, "search"
+		}
+	}
+	return "", ""
+}
+
+// 确定使用的配置文件，未找到时退出
+func findConfig() string {
+	path, _ := lookupConfig()
+	if path == "" {
+		errOutput("Config error: no config file found, searched:")
+		for _, c := range configCandidates() {
+			errOutput("  " + c)
+		}
+		errOutput("Run \033[33mqcip init\033[31m to create one, or use -c or " + configEnv + " to specify it")
+		errExit()
+	}
+	return path
+}
+
+// qcip config path，输出将会使用的配置文件
+func configPathCommand(showAll bool) {
+	path, source := lookupConfig()
+	if showAll {
+		// * 为将会使用的文件，- 为存在但优先级较低的文件
+		mark := func(c string, used bool) string {
+			if used {
+				return "*"
+			}
+			if _, err := os.Stat(c); err == nil {
+				return "-"
+			}
+			return " "
+		}
+		fmt.Printf("Search order:\n")
+		if confPath != "" {
+			fmt.Printf("  %s -c %s\n", mark(confPath, source == "-c"), confPath)
+		}
+		if env := os.Getenv(configEnv); env != "" {
+			fmt.Printf("  %s $%s %s\n", mark(env, source == configEnv), configEnv, env)
+		}
+		for _, c := range configCandidates() {
+			fmt.Printf("  %s %s\n", mark(c, source == "search" && c == path), c)
+		}
+	}
+	if path == "" {
+		findConfig()
+	}
+	fmt.Println(path)
+	if _, err := os.Stat(path); err != nil {
+		errOutput("Config error: config file " + path + " does not exist")
+		errExit()
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// 切换到 dir，测试结束后恢复
+func chdir(t *testing.T, dir string) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func TestLookupConfig(t *testing.T) {
+	tests := []struct {
+		name       string
+		flag       string
+		env        string
+		files      []string // 相对于当前目录或 xdg/ 下的文件
+		wantPath   string   // xdg/ 开头时为 XDG_CONFIG_HOME 下的路径
+		wantSource string
+	}{
+		{"flag first", "given.yaml", "env.json", []string{"qcip.json", "xdg/qcip/config.json"}, "given.yaml", "-c"},
+		{"flag not existing", "missing.json", "", nil, "missing.json", "-c"},
+		{"env before search", "", "env.json", []string{"qcip.json"}, "env.json", configEnv},
+		{"current directory", "", "", []string{"qcip.json", "config.json", "xdg/qcip/config.json"}, "qcip.json", "search"},
+		{"legacy config.json", "", "", []string{"config.json", "xdg/qcip/config.yaml"}, "config.json", "search"},
+		{"xdg config dir", "", "", []string{"xdg/qcip/config.yaml", "xdg/qcip/config.toml"}, "xdg/qcip/config.yaml", "search"},
+		{"xdg plain before encrypted", "", "", []string{"xdg/qcip/config.toml", "xdg/qcip/config.json.age"}, "xdg/qcip/config.toml", "search"},
+		{"xdg encrypted", "", "", []string{"xdg/qcip/config.yaml.age"}, "xdg/qcip/config.yaml.age", "search"},
+		{"directory is skipped", "", "", []string{"qcip.json/", "xdg/qcip/config.yml"}, "xdg/qcip/config.yml", "search"},
+	}
+	savedPath := confPath
+	defer func() { confPath = savedPath }()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			chdir(t, dir)
+			t.Setenv("XDG_CONFIG_HOME", filepath.Join(dir, "xdg"))
+			t.Setenv(configEnv, tt.env)
+			confPath = tt.flag
+			for _, f := range tt.files {
+				if strings.HasSuffix(f, "/") {
+					os.MkdirAll(f, 0755)
+					continue
+				}
+				os.MkdirAll(filepath.Dir(f), 0755)
+				if err := os.WriteFile(f, []byte("{}"), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want := tt.wantPath
+			if strings.HasPrefix(want, "xdg/") {
+				want = filepath.Join(dir, want)
+			}
+			path, source := lookupConfig()
+			if path != want || source != tt.wantSource {
+				t.Fatalf("got %s from %s, want %s from %s", path, source, want, tt.wantSource)
+			}
+		})
+	}
+}
+
+func TestConfigCandidatesOrder(t *testing.T) {
+	xdg := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", xdg)
+	candidates := configCandidates()
+	want := []string{"qcip.json", "config.json", filepath.Join(xdg, "qcip", "config.json")}
+	for i, w := range want {
+		if candidates[i] != w {
+			t.Fatalf("candidate %d is %s, want %s", i, candidates[i], w)
+		}
+	}
+	if goos != "windows" && candidates[len(candidates)-1] != "/etc/qcip/config.toml.age" {
+		t.Fatalf("last candidate is %s, want /etc/qcip/config.toml.age", candidates[len(candidates)-1])
+	}
+}
+
+// 未找到配置文件时列出查找过的每个路径并退出
+func TestFindConfigNotFound(t *testing.T) {
+	if _, err := os.Stat("/etc/qcip"); err == nil {
+		t.Skip("/etc/qcip exists on this machine")
+	}
+	xdg := t.TempDir()
+	stdout, stderr, code := runQcip(t, t.TempDir(), []string{"XDG_CONFIG_HOME=" + xdg}, "config", "path")
+	if code != 1 {
+		t.Fatalf("exit code %d, want 1\n%s%s", code, stdout, stderr)
+	}
+	out := stdout + stderr
+	if !strings.Contains(out, "no config file found") {
+		t.Fatalf("output does not explain the error:\n%s", out)
+	}
+	t.Setenv("XDG_CONFIG_HOME", xdg)
+	for _, c := range configCandidates() {
+		if !strings.Contains(out, "  "+c+"\033[0m\n") {
+			t.Errorf("searched path %s is not listed in:\n%s", c, out)
+		}
+	}
+}
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	al_openapi "github.com/alibabacloud-go/darabonba-openapi/v2/client"
+	al_util "github.com/alibabacloud-go/tea-utils/v2/service"
+	"github.com/alibabacloud-go/tea/tea"
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
+	tchttp "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/http"
+	"gopkg.in/ini.v1"
+)
+
+// 凭证来源
+const (
+	credentialStatic   = "static"   // 配置文件或环境变量中的密钥，默认
+	credentialProfile  = "profile"  // 腾讯云 ~/.tencentcloud/credentials 或阿里云 ~/.aliyun/config.json
+	credentialMetadata = "metadata" // 云服务器实例元数据中的角色凭证
+	credentialChain    = "chain"    // 依次尝试 static profile metadata
+)
+
+var (
+	qcMetadataEndpoint = "http://metadata.tencentyun.com/latest/meta-data/" // 腾讯云实例元数据地址
+	alMetadataEndpoint = "http://100.100.100.200/latest/meta-data/"         // 阿里云实例元数据地址
+	defaultRoleSession = "qcip"                                             // 扮演角色时的默认会话名称
+	metadataClient     = &http.Client{Timeout: 3 * time.Second, Transport: &http.Transport{}}
+)
+
+// 与云服务商无关的凭证，Token 为临时凭证的会话令牌
+type cloudCredential struct {
+	SecretId  string
+	SecretKey string
+	Token     string
+}
+
+// 获取凭证，出错时退出
+func getCredential(configData Config) cloudCredential {
+	credential, err := resolveCredential(configData)
+	if err != nil {
+		errOutput("Credential error:")
+		errDetail(err)
+		errExit()
+	}
+	return credential
+}
+
+// 按照 CredentialSource 获取凭证，配置了 RoleArn 时以其为基础凭证扮演角色
+func resolveCredential(configData Config) (cloudCredential, error) {
+	var (
+		credential cloudCredential
+		err        error
+	)
+	source := configData.CredentialSource
+	if source == "" {
+		source = credentialStatic
+	}
+	if source == credentialChain {
+		var errs []string
+		for _, s := range []string{credentialStatic, credentialProfile, credentialMetadata} {
+			if credential, err = credentialFromSource(configData, s); err == nil {
+				break
+			}
+			errs = append(errs, s+": "+err.Error())
+		}
+		if err != nil {
+			return credential, errors.New("no credential found in the chain\n    " + strings.Join(errs, "\n    "))
+		}
+	} else if credential, err = credentialFromSource(configData, source); err != nil {
+		return credential, err
+	}
+	addSecrets(credential.SecretId, credential.SecretKey, credential.Token)
+	if configData.RoleArn != "" {
+		if configData.MType == "allh" {
+			credential, err = alAssumeRole(credential, configData)
+		} else {
+			credential, err = qcAssumeRole(credential, configData)
+		}
+		addSecrets(credential.SecretId, credential.SecretKey, credential.Token)
+	}
+	return credential, err
+}
+
+func credentialFromSource(configData Config, source string) (cloudCredential, error) {
+	isAL := configData.MType == "allh"
+	switch source {
+	case credentialStatic:
+		if configData.SecretId == "" || configData.SecretKey == "" {
+			return cloudCredential{}, errors.New("SecretId and SecretKey are not set")
+		}
+		return cloudCredential{SecretId: configData.SecretId, SecretKey: configData.SecretKey, Token: configData.SessionToken}, nil
+	case credentialProfile:
+		if isAL {
+			return alProfileCredential(configData)
+		}
+		return qcProfileCredential(configData.Profile)
+	case credentialMetadata:
+		if isAL {
+			return alMetadataCredential(configData.MetadataEndpoint, configData.RoleName)
+		}
+		return qcMetadataCredential(configData.MetadataEndpoint, configData.RoleName)
+	}
+	return cloudCredential{}, errors.New("unknown credential source " + source)
+}
+
+// 读取腾讯云凭证文件，路径可通过 TENCENTCLOUD_CREDENTIALS_FILE 指定
+func qcProfileCredential(profile string) (cloudCredential, error) {
+	if profile == "" {
+		profile = "default"
+	}
+	path := os.Getenv(common.EnvCredentialFile)
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return cloudCredential{}, err
+		}
+		path = filepath.Join(home, ".tencentcloud", "credentials")
+	}
+	file, err := ini.Load(path)
+	if err != nil {
+		return cloudCredential{}, err
+	}
+	section, err := file.GetSection(profile)
+	if err != nil {
+		return cloudCredential{}, errors.New("profile " + profile + " not found in " + path)
+	}
+	credential := cloudCredential{
+		SecretId:  section.Key("secret_id").String(),
+		SecretKey: section.Key("secret_key").String(),
+		Token:     section.Key("token").String(),
+	}
+	if credential.SecretId == "" || credential.SecretKey == "" {
+		return cloudCredential{}, errors.New("profile " + profile + " in " + path + " does not contain secret_id and secret_key")
+	}
+	return credential, nil
+}
+
+// 阿里云 CLI 配置文件 ~/.aliyun/config.json
+type alCLIConfig struct {
+	Current  string `json:"current"`
+	Profiles []struct {
+		Name            string `json:"name"`
+		Mode            string `json:"mode"`
+		AccessKeyId     string `json:"access_key_id"`
+		AccessKeySecret string `json:"access_key_secret"`
+		StsToken        string `json:"sts_token"`
+		RamRoleName     string `json:"ram_role_name"`
+		RamRoleArn      string `json:"ram_role_arn"`
+		RamSessionName  string `json:"ram_session_name"`
+	} `json:"profiles"`
+}
+
+// 读取阿里云 CLI 的配置文件，支持 AK StsToken RamRoleArn EcsRamRole 模式
+func alProfileCredential(configData Config) (cloudCredential, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return cloudCredential{}, err
+	}
+	path := filepath.Join(home, ".aliyun", "config.json")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cloudCredential{}, err
+	}
+	var cliConfig alCLIConfig
+	if err = json.Unmarshal(data, &cliConfig); err != nil {
+		return cloudCredential{}, errors.New("failed to parse " + path + ": " + err.Error())
+	}
+	name := configData.Profile
+	if name == "" {
+		name = cliConfig.Current
+	}
+	if name == "" {
+		name = "default"
+	}
+	for _, p := range cliConfig.Profiles {
+		if p.Name != name {
+			continue
+		}
+		base := cloudCredential{SecretId: p.AccessKeyId, SecretKey: p.AccessKeySecret, Token: p.StsToken}
+		switch p.Mode {
+		case "", "AK", "StsToken":
+			if base.SecretId == "" || base.SecretKey == "" {
+				return cloudCredential{}, errors.New("profile " + name + " in " + path + " does not contain access_key_id and access_key_secret")
+			}
+			return base, nil
+		case "RamRoleArn":
+			roleConfig := configData
+			roleConfig.RoleArn = p.RamRoleArn
+			if p.RamSessionName != "" {
+				roleConfig.RoleSessionName = p.RamSessionName
+			}
+			return alAssumeRole(base, roleConfig)
+		case "EcsRamRole":
+			return alMetadataCredential(configData.MetadataEndpoint, p.RamRoleName)
+		}
+		return cloudCredential{}, errors.New("profile mode " + p.Mode + " is not supported")
+	}
+	return cloudCredential{}, errors.New("profile " + name + " not found in " + path)
+}
+
+// 从腾讯云实例元数据获取 CAM 角色的临时凭证
+func qcMetadataCredential(endpoint string, roleName string) (cloudCredential, error) {
+	if endpoint == "" {
+		endpoint = qcMetadataEndpoint
+	}
+	base := strings.TrimSuffix(endpoint, "/") + "/cam/security-credentials/"
+	if roleName == "" {
+		name, err := metadataGet(base, nil)
+		if err != nil {
+			return cloudCredential{}, errors.New("no CAM role is bound to this instance: " + err.Error())
+		}
+		roleName = strings.TrimSpace(strings.SplitN(string(name), "\n", 2)[0])
+	}
+	body, err := metadataGet(base+roleName, nil)
+	if err != nil {
+		return cloudCredential{}, err
+	}
+	var resp struct {
+		TmpSecretId  string
+		TmpSecretKey string
+		Token        string
+		Code         string
+	}
+	if err = json.Unmarshal(body, &resp); err != nil {
+		return cloudCredential{}, errors.New("invalid metadata response: " + err.Error())
+	}
+	if resp.Code != "Success" {
+		return cloudCredential{}, errors.New("failed to get credential of role " + roleName + ", code=" + resp.Code)
+	}
+	return cloudCredential{SecretId: resp.TmpSecretId, SecretKey: resp.TmpSecretKey, Token: resp.Token}, nil
+}
+
+// 从阿里云实例元数据获取 RAM 角色的临时凭证，优先使用加固模式的令牌
+func alMetadataCredential(endpoint string, roleName string) (cloudCredential, error) {
+	if endpoint == "" {
+		endpoint = alMetadataEndpoint
+	}
+	endpoint = strings.TrimSuffix(endpoint, "/")
+	header := make(http.Header)
+	tokenURL := strings.TrimSuffix(endpoint, "/meta-data") + "/api/token"
+	if req, err := http.NewRequest("PUT", tokenURL, nil); err == nil {
+		req.Header.Set("X-aliyun-ecs-metadata-token-ttl-seconds", "60")
+		if resp, err := metadataClient.Do(req); err == nil {
+			token, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				header.Set("X-aliyun-ecs-metadata-token", string(token))
+			}
+		}
+	}
+	base := endpoint + "/ram/security-credentials/"
+	if roleName == "" {
+		name, err := metadataGet(base, header)
+		if err != nil {
+			return cloudCredential{}, errors.New("no RAM role is bound to this instance: " + err.Error())
+		}
+		roleName = strings.TrimSpace(strings.SplitN(string(name), "\n", 2)[0])
+	}
+	body, err := metadataGet(base+roleName, header)
+	if err != nil {
+		return cloudCredential{}, err
+	}
+	var resp struct {
+		AccessKeyId     string
+		AccessKeySecret string
+		SecurityToken   string
+		Code            string
+	}
+	if err = json.Unmarshal(body, &resp); err != nil {
+		return cloudCredential{}, errors.New("invalid metadata response: " + err.Error())
+	}
+	if resp.Code != "Success" {
+		return cloudCredential{}, errors.New("failed to get credential of role " + roleName + ", code=" + resp.Code)
+	}
+	return cloudCredential{SecretId: resp.AccessKeyId, SecretKey: resp.AccessKeySecret, Token: resp.SecurityToken}, nil
+}
+
+func metadataGet(url string, header http.Header) ([]byte, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := metadataClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &httpStatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func roleSessionName(configData Config) string {
+	if configData.RoleSessionName != "" {
+		return configData.RoleSessionName
+	}
+	return defaultRoleSession
+}
+
+func roleDuration(configData Config) int64 {
+	if configData.RoleDuration > 0 {
+		return int64(time.Duration(configData.RoleDuration) / time.Second)
+	}
+	return 3600
+}
+
+// 通过腾讯云 STS AssumeRole 扮演 CAM 角色
+func qcAssumeRole(base cloudCredential, configData Config) (cloudCredential, error) {
+	client := common.NewCommonClient(common.NewTokenCredential(base.SecretId, base.SecretKey, base.Token), "ap-guangzhou", QCClientProfile("sts.tencentcloudapi.com"))
+	client.WithHttpTransport(apiTransport)
+	request := tchttp.NewCommonRequest("sts", "2018-08-13", "AssumeRole")
+	err := request.SetActionParameters(map[string]interface{}{
+		"RoleArn":         configData.RoleArn,
+		"RoleSessionName": roleSessionName(configData),
+		"DurationSeconds": roleDuration(configData),
+	})
+	if err != nil {
+		return cloudCredential{}, err
+	}
+	response := tchttp.NewCommonResponse()
+	if err = retrier.do(func() error { return client.Send(request, response) }); err != nil {
+		return cloudCredential{}, errors.New("failed to assume role " + configData.RoleArn + ": " + err.Error())
+	}
+	var resp struct {
+		Response struct {
+			Credentials struct {
+				Token        string
+				TmpSecretId  string
+				TmpSecretKey string
+			}
+		}
+	}
+	if err = json.Unmarshal(response.GetBody(), &resp); err != nil {
+		return cloudCredential{}, err
+	}
+	c := resp.Response.Credentials
+	return cloudCredential{SecretId: c.TmpSecretId, SecretKey: c.TmpSecretKey, Token: c.Token}, nil
+}
+
+// 通过阿里云 STS AssumeRole 扮演 RAM 角色
+func alAssumeRole(base cloudCredential, configData Config) (cloudCredential, error) {
+	config := &al_openapi.Config{
+		AccessKeyId:     tea.String(base.SecretId),
+		AccessKeySecret: tea.String(base.SecretKey),
+		Endpoint:        tea.String("sts.aliyuncs.com"),
+	}
+	if base.Token != "" {
+		config.SecurityToken = tea.String(base.Token)
+	}
+	if err := ALSetProxy(config, apiProxy); err != nil {
+		return cloudCredential{}, err
+	}
+	client, err := al_openapi.NewClient(config)
+	if err != nil {
+		return cloudCredential{}, err
+	}
+	params := &al_openapi.Params{
+		Action:      tea.String("AssumeRole"),
+		Version:     tea.String("2015-04-01"),
+		Protocol:    tea.String("HTTPS"),
+		Pathname:    tea.String("/"),
+		Method:      tea.String("POST"),
+		AuthType:    tea.String("AK"),
+		Style:       tea.String("RPC"),
+		ReqBodyType: tea.String("formData"),
+		BodyType:    tea.String("json"),
+	}
+	request := &al_openapi.OpenApiRequest{Query: map[string]*string{
+		"RoleArn":         tea.String(configData.RoleArn),
+		"RoleSessionName": tea.String(roleSessionName(configData)),
+		"DurationSeconds": tea.String(strconv.FormatInt(roleDuration(configData), 10)),
+	}}
+	var result map[string]interface{}
+	err = retrier.do(func() (err error) {
+		result, err = client.CallApi(params, request, &al_util.RuntimeOptions{})
+		return err
+	})
+	if err != nil {
+		return cloudCredential{}, errors.New("failed to assume role " + configData.RoleArn + ": " + err.Error())
+	}
+	raw, _ := json.Marshal(result["body"])
+	var resp struct {
+		Credentials struct {
+			AccessKeyId     string
+			AccessKeySecret string
+			SecurityToken   string
+		}
+	}
+	if err = json.Unmarshal(raw, &resp); err != nil {
+		return cloudCredential{}, err
+	}
+	c := resp.Credentials
+	return cloudCredential{SecretId: c.AccessKeyId, SecretKey: c.AccessKeySecret, Token: c.SecurityToken}, nil
+}
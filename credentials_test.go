@@ -0,0 +1,88 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// 模拟腾讯云实例元数据服务
+func newFakeQCMetadata(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/latest/meta-data/cam/security-credentials/", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "qcip-role\n")
+	})
+	mux.HandleFunc("/latest/meta-data/cam/security-credentials/qcip-role", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"TmpSecretId":"AKIDtmp","TmpSecretKey":"tmpkey","Token":"tmptoken","ExpiredTime":1700000000,"Code":"Success"}`)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// 模拟阿里云实例元数据服务，只接受加固模式的请求
+func newFakeALMetadata(t *testing.T) *httptest.Server {
+	const token = "metadata-token"
+	mux := http.NewServeMux()
+	mux.HandleFunc("/latest/api/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PUT" || r.Header.Get("X-aliyun-ecs-metadata-token-ttl-seconds") == "" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		io.WriteString(w, token)
+	})
+	mux.HandleFunc("/latest/meta-data/ram/security-credentials/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-aliyun-ecs-metadata-token") != token {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		role := r.URL.Path[len("/latest/meta-data/ram/security-credentials/"):]
+		switch role {
+		case "":
+			io.WriteString(w, "qcip-ram-role")
+		case "qcip-ram-role":
+			io.WriteString(w, `{"AccessKeyId":"STS.tmp","AccessKeySecret":"tmpsecret","SecurityToken":"tmptoken","Code":"Success"}`)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestMetadataCredential(t *testing.T) {
+	qc := newFakeQCMetadata(t)
+	al := newFakeALMetadata(t)
+	tests := []struct {
+		mtype    string
+		endpoint string
+		role     string
+		want     cloudCredential
+	}{
+		{"lh", qc.URL + "/latest/meta-data/", "", cloudCredential{SecretId: "AKIDtmp", SecretKey: "tmpkey", Token: "tmptoken"}},
+		{"cvm", qc.URL + "/latest/meta-data", "qcip-role", cloudCredential{SecretId: "AKIDtmp", SecretKey: "tmpkey", Token: "tmptoken"}},
+		{"allh", al.URL + "/latest/meta-data/", "", cloudCredential{SecretId: "STS.tmp", SecretKey: "tmpsecret", Token: "tmptoken"}},
+		{"allh", al.URL + "/latest/meta-data", "qcip-ram-role", cloudCredential{SecretId: "STS.tmp", SecretKey: "tmpsecret", Token: "tmptoken"}},
+	}
+	for _, tt := range tests {
+		configData := Config{MType: tt.mtype, CredentialSource: credentialMetadata, MetadataEndpoint: tt.endpoint, RoleName: tt.role}
+		got, err := resolveCredential(configData)
+		if err != nil {
+			t.Errorf("%s %s: %v", tt.mtype, tt.endpoint, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s %s: got %+v, want %+v", tt.mtype, tt.endpoint, got, tt.want)
+		}
+	}
+}
+
+func TestMetadataCredentialUnknownRole(t *testing.T) {
+	al := newFakeALMetadata(t)
+	configData := Config{MType: "allh", CredentialSource: credentialMetadata, MetadataEndpoint: al.URL + "/latest/meta-data/", RoleName: "missing"}
+	if _, err := resolveCredential(configData); err == nil {
+		t.Fatal("expected an error for a role that is not bound to the instance")
+	}
+}
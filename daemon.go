@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+const minRunInterval = 10 * time.Second
+
+var runInterval time.Duration // --interval 指定的运行间隔，为 0 时只运行一次
+
+// 每隔 interval 检查并修改一次规则，出错时不退出，收到 SIGINT 或 SIGTERM 时退出
+func runDaemon(interval time.Duration) {
+	daemonMode = true
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	logger.Info("Started", "interval", interval.String())
+	for {
+		runDaemonOnce()
+		select {
+		case <-ctx.Done():
+			logger.Info("Stopped")
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+func runDaemonOnce() {
+	defer func() {
+		if r := recover(); r != nil && r != errRunFailed {
+			panic(r)
+		}
+	}()
+	errMsgList = make(map[int]string)
+	errHandleTimes = 0
+	pendingErr, pendingErrCode = nil, ""
+	startReport("run")
+	runOnce()
+	report.finish()
+	logger.Info("Next run", "interval", runInterval.String())
+}
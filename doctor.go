@@ -0,0 +1,244 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	al_swas_open "github.com/alibabacloud-go/swas-open-20200601/client"
+	"github.com/alibabacloud-go/tea/tea"
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
+	qc_errors "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/errors"
+	qc_lighthouse "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/lighthouse/v20200324"
+	qc_vpc "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/vpc/v20170312"
+)
+
+// 检查结果
+const (
+	doctorPass = "\033[32m PASS \033[0m"
+	doctorFail = "\033[31m FAIL \033[0m"
+	doctorSkip = "\033[33m SKIP \033[0m"
+	doctorWarn = "\033[33m WARN \033[0m"
+)
+
+var doctorFailures = 0
+
+func doctorResult(status string, name string, detail string) {
+	if status == doctorFail {
+		doctorFailures++
+	}
+	fmt.Printf("  [%s] %-20s %s\n", status, name, redact(detail))
+}
+
+// qcip doctor，逐项检查配置、IP 获取、凭证和权限，不修改任何规则
+func doctorCommand() {
+	confPath = findConfig()
+	fmt.Printf("Checking \033[1m%s\033[0m\n", confPath)
+	runDoctor(confPath)
+	if doctorFailures > 0 {
+		fmt.Printf("\033[31m%d check(s) failed\033[0m\n", doctorFailures)
+		os.Exit(1)
+	}
+	fmt.Printf("\033[1;32mAll checks passed\033[0m\n")
+}
+
+func runDoctor(path string) {
+	skipRest := func(names ...string) {
+		for _, name := range names {
+			doctorResult(doctorSkip, name, "")
+		}
+	}
+	configData, errs, err := loadConfig(path)
+	if err != nil {
+		doctorResult(doctorFail, "Config file", err.Error())
+	}
+	errs, warnings := splitConfigErrors(errs)
+	for _, e := range errs {
+		doctorResult(doctorFail, "Config file", e.format(path))
+	}
+	for _, w := range warnings {
+		doctorResult(doctorWarn, "Config file", w.format(path))
+	}
+	if err != nil || len(errs) > 0 {
+		skipRest("Proxy", "Public IP", "Credential", "Read permission", "Rules", "Modify permission")
+		return
+	}
+	doctorResult(doctorPass, "Config file", configData.MType+", "+strconv.Itoa(len(configData.Rules))+" rule(s)")
+	retrier = newRetryPolicy(int(configData.MaxRetries), time.Duration(configData.RetryMaxElapsed))
+
+	proxyOK := true
+	if httpClient, err = newIPHTTPClient(configData.IPProxy); err != nil {
+		doctorResult(doctorFail, "Proxy", "IPProxy: "+err.Error())
+		proxyOK = false
+	}
+	apiProxy = configData.APIProxy
+	if apiTransport, err = newAPITransport(apiProxy); err != nil {
+		doctorResult(doctorFail, "Proxy", "APIProxy: "+err.Error())
+		proxyOK = false
+	}
+	if proxyOK {
+		doctorResult(doctorPass, "Proxy", "IPProxy "+proxyDescription(configData.IPProxy, proxyDirect)+", APIProxy "+proxyDescription(configData.APIProxy, proxyEnv))
+	}
+
+	api := configData.GetIPAPI
+	if api == "" {
+		api = "IPCONF"
+	}
+	if !proxyOK {
+		skipRest("Public IP")
+	} else if ip, err := fetchIPaddr(api, configData.GatewayAddr, int(configData.MaxRetries)); err != nil {
+		doctorResult(doctorFail, "Public IP", api+": "+err.Error())
+	} else if err = checkIPaddr(ip, configData.AllowIPRanges); err != nil {
+		doctorResult(doctorFail, "Public IP", api+": "+err.Error())
+	} else {
+		doctorResult(doctorPass, "Public IP", api+": "+ip)
+	}
+
+	cred, err := resolveCredential(configData)
+	if err != nil {
+		doctorResult(doctorFail, "Credential", err.Error())
+		skipRest("Read permission", "Rules", "Modify permission")
+		return
+	}
+	source := configData.CredentialSource
+	if source == "" {
+		source = credentialStatic
+	}
+	if configData.RoleArn != "" {
+		source += ", assumed " + configData.RoleArn
+	}
+	doctorResult(doctorPass, "Credential", source)
+	if !proxyOK {
+		skipRest("Read permission", "Rules", "Modify permission")
+		return
+	}
+
+	var (
+		target       string
+		descriptions []string
+	)
+	switch configData.MType {
+	case "lh":
+		target = "instance " + configData.InstanceId
+		credential := common.NewTokenCredential(cred.SecretId, cred.SecretKey, cred.Token)
+		var rules []*qc_lighthouse.FirewallRuleInfo
+		if rules, err = QClhFetchRules(credential, configData.InstanceRegion, configData.InstanceId); err == nil {
+			for _, r := range rules {
+				descriptions = append(descriptions, tea.StringValue(r.FirewallRuleDescription))
+			}
+		}
+	case "cvm":
+		target = "security group " + configData.SecurityGroupId
+		credential := common.NewTokenCredential(cred.SecretId, cred.SecretKey, cred.Token)
+		var rules *qc_vpc.SecurityGroupPolicySet
+		if rules, err = QCcvmFetchRules(credential, configData.SecurityGroupId, configData.SecurityGroupRegion); err == nil && rules != nil {
+			for _, r := range rules.Ingress {
+				descriptions = append(descriptions, tea.StringValue(r.PolicyDescription))
+			}
+		}
+	case "allh":
+		target = "instance " + configData.InstanceId
+		var client *al_swas_open.Client
+		if client, err = ALCreateClient(tea.String(cred.SecretId), tea.String(cred.SecretKey), tea.String(cred.Token)); err == nil {
+			var rules []*al_swas_open.ListFirewallRulesResponseBodyFirewallRules
+			if rules, err = ALlhFetchRules(client, configData.InstanceRegion, configData.InstanceId); err == nil {
+				for _, r := range rules {
+					descriptions = append(descriptions, tea.StringValue(r.Remark))
+				}
+			}
+		}
+	}
+	if err != nil {
+		reason := err.Error()
+		if isPermissionError(err) {
+			reason = "authentication failed or no read permission: " + reason
+		}
+		doctorResult(doctorFail, "Read permission", target+": "+reason)
+		skipRest("Rules", "Modify permission")
+		return
+	}
+	doctorResult(doctorPass, "Read permission", target+" found, "+strconv.Itoa(len(descriptions))+" rule(s)")
+
+	for _, rule := range configData.Rules {
+		matched := 0
+		for _, d := range descriptions {
+			if d == rule {
+				matched++
+			}
+		}
+		if matched == 0 {
+			doctorResult(doctorFail, "Rules", strconv.Quote(rule)+" does not match any rule")
+		} else {
+			doctorResult(doctorPass, "Rules", strconv.Quote(rule)+" matches "+strconv.Itoa(matched)+" rule(s)")
+		}
+	}
+
+	doctorModifyPermission(configData, cred)
+}
+
+// 云 API 没有可以不修改规则的检测方式，因此读取调用者关联的策略，检查是否授予 qcip policy 中的接口
+func doctorModifyPermission(configData Config, cred cloudCredential) {
+	provider := "tencent"
+	readPolicies := qcCallerPolicies
+	if configData.MType == "allh" {
+		provider = "alibaba"
+		readPolicies = alCallerPolicies
+	}
+	caller, err := readPolicies(cred)
+	if err != nil {
+		doctorResult(doctorWarn, "Modify permission", "unverified, cannot read the policies of the key: "+err.Error()+
+			"; grant "+strings.Join(permissionCheckActions[provider], ", ")+" to let doctor check it")
+		return
+	}
+	if caller.Root {
+		doctorResult(doctorPass, "Modify permission", "the key belongs to the main account")
+		return
+	}
+	missing, err := missingActions(caller.Docs, providerActions[configData.MType], policyResource(configData, caller.Account))
+	if err != nil {
+		doctorResult(doctorWarn, "Modify permission", "unverified, "+err.Error())
+		return
+	}
+	if len(missing) > 0 {
+		doctorResult(doctorFail, "Modify permission", "not granted by the "+strconv.Itoa(len(caller.Docs))+" attached policies: "+strings.Join(missing, ", "))
+		return
+	}
+	doctorResult(doctorPass, "Modify permission", "granted by the attached policies")
+}
+
+func proxyDescription(setting string, def string) string {
+	if setting == "" {
+		return def
+	}
+	if u, err := parseProxyURL(setting); err == nil {
+		return u.Redacted()
+	}
+	return setting
+}
+
+// 云 API 返回的错误码，网络错误等返回空字符串
+func apiErrorCode(err error) string {
+	var qcErr *qc_errors.TencentCloudSDKError
+	if errors.As(err, &qcErr) && !strings.HasPrefix(qcErr.Code, "ClientError.") {
+		return qcErr.Code
+	}
+	var alErr *tea.SDKError
+	if errors.As(err, &alErr) && alErr.Code != nil {
+		return *alErr.Code
+	}
+	return ""
+}
+
+// 判断错误是否为鉴权失败或权限不足
+func isPermissionError(err error) bool {
+	code := apiErrorCode(err)
+	for _, prefix := range []string{"AuthFailure", "UnauthorizedOperation", "Forbidden", "NoPermission", "InvalidAccessKeyId", "SignatureDoesNotMatch", "IncompleteSignature", "InvalidSecurityToken"} {
+		if strings.HasPrefix(code, prefix) {
+			return true
+		}
+	}
+	return false
+}
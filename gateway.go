@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	ssdpAddr       = "239.255.255.250:1900"                                // SSDP 组播地址
+	igdDeviceType  = "urn:schemas-upnp-org:device:InternetGatewayDevice:1" // UPnP IGD 设备类型
+	natpmpPort     = "5351"                                                // NAT-PMP / PCP 服务端口
+	routerTimeout  = 250 * time.Millisecond                                // NAT-PMP / PCP 首次重传间隔
+	ssdpWaitTime   = 3 * time.Second                                       // 等待 SSDP 响应的时间
+	pcpMapLifetime = 120                                                   // PCP 临时映射的有效期(秒)
+)
+
+// 访问局域网网关使用的客户端，不经过 IPProxy 或环境变量中的代理
+var gatewayClient = &http.Client{
+	Timeout: time.Second * 10,
+	Transport: &http.Transport{
+		Proxy: nil,
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "tcp4", addr)
+		},
+	},
+}
+
+// UPnP 设备描述文件
+type upnpDevice struct {
+	DeviceType string `xml:"deviceType"`
+	Services   []struct {
+		ServiceType string `xml:"serviceType"`
+		ControlURL  string `xml:"controlURL"`
+	} `xml:"serviceList>service"`
+	Devices []upnpDevice `xml:"deviceList>device"`
+}
+
+type upnpRoot struct {
+	URLBase string     `xml:"URLBase"`
+	Device  upnpDevice `xml:"device"`
+}
+
+// 通过 UPnP IGD 的 GetExternalIPAddress 获取公网IP
+// gatewayAddr 为空时通过 SSDP 组播发现网关；为 http(s) 链接时直接使用该设备描述文件；否则作为 SSDP 单播地址
+func getIPfromUPnP(gatewayAddr string) (string, error) {
+	location := gatewayAddr
+	if !strings.HasPrefix(gatewayAddr, "http://") && !strings.HasPrefix(gatewayAddr, "https://") {
+		target := ssdpAddr
+		if gatewayAddr != "" {
+			target = withDefaultPort(gatewayAddr, "1900")
+		}
+		var err error
+		location, err = ssdpDiscover(target)
+		if err != nil {
+			return "", err
+		}
+	}
+	serviceType, controlURL, err := upnpFindWANService(location)
+	if err != nil {
+		return "", err
+	}
+	return upnpGetExternalIP(serviceType, controlURL)
+}
+
+// 发送 SSDP M-SEARCH 请求，返回网关设备描述文件的地址
+func ssdpDiscover(target string) (string, error) {
+	addr, err := net.ResolveUDPAddr("udp4", target)
+	if err != nil {
+		return "", err
+	}
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	msg := "M-SEARCH * HTTP/1.1\r\n" +
+		"HOST: " + ssdpAddr + "\r\n" +
+		"ST: " + igdDeviceType + "\r\n" +
+		"MAN: \"ssdp:discover\"\r\n" +
+		"MX: 2\r\n\r\n"
+	if _, err = conn.WriteTo([]byte(msg), addr); err != nil {
+		return "", err
+	}
+	if err = conn.SetReadDeadline(time.Now().Add(ssdpWaitTime)); err != nil {
+		return "", err
+	}
+	buf := make([]byte, 2048)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return "", fmt.Errorf("no UPnP gateway responded: %w", err)
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+		if err != nil {
+			continue
+		}
+		location := resp.Header.Get("Location")
+		if location != "" {
+			return location, nil
+		}
+	}
+}
+
+// 在设备描述文件中查找 WANIPConnection 或 WANPPPConnection 服务
+func upnpFindWANService(location string) (string, string, error) {
+	req, err := http.NewRequest("GET", location, nil)
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Set("User-Agent", ua)
+	resp, err := gatewayClient.Do(req)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", "", &httpStatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+	var root upnpRoot
+	if err = xml.NewDecoder(resp.Body).Decode(&root); err != nil {
+		return "", "", errors.New("invalid UPnP description: " + err.Error())
+	}
+	base, err := url.Parse(location)
+	if err != nil {
+		return "", "", err
+	}
+	if root.URLBase != "" {
+		if b, err := url.Parse(root.URLBase); err == nil {
+			base = b
+		}
+	}
+	var find func(d upnpDevice) (string, string, bool)
+	find = func(d upnpDevice) (string, string, bool) {
+		for _, s := range d.Services {
+			if strings.HasPrefix(s.ServiceType, "urn:schemas-upnp-org:service:WANIPConnection:") ||
+				strings.HasPrefix(s.ServiceType, "urn:schemas-upnp-org:service:WANPPPConnection:") {
+				return s.ServiceType, strings.TrimSpace(s.ControlURL), true
+			}
+		}
+		for _, sub := range d.Devices {
+			if t, c, ok := find(sub); ok {
+				return t, c, true
+			}
+		}
+		return "", "", false
+	}
+	serviceType, control, ok := find(root.Device)
+	if !ok {
+		return "", "", errors.New("gateway does not provide a WAN connection service")
+	}
+	controlURL, err := base.Parse(control)
+	if err != nil {
+		return "", "", err
+	}
+	return serviceType, controlURL.String(), nil
+}
+
+// 调用 GetExternalIPAddress
+func upnpGetExternalIP(serviceType string, controlURL string) (string, error) {
+	body := `<?xml version="1.0"?>` +
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">` +
+		`<s:Body><u:GetExternalIPAddress xmlns:u="` + serviceType + `"></u:GetExternalIPAddress></s:Body></s:Envelope>`
+	req, err := http.NewRequest("POST", controlURL, strings.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", ua)
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", `"`+serviceType+`#GetExternalIPAddress"`)
+	resp, err := gatewayClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", &httpStatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+	decoder := xml.NewDecoder(resp.Body)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return "", errors.New("GetExternalIPAddress response contains no address")
+		}
+		if err != nil {
+			return "", err
+		}
+		if start, ok := token.(xml.StartElement); ok && start.Name.Local == "NewExternalIPAddress" {
+			var ip string
+			if err = decoder.DecodeElement(&ip, &start); err != nil {
+				return "", err
+			}
+			return strings.TrimSpace(ip), nil
+		}
+	}
+}
+
+// 通过 NAT-PMP (RFC 6886) 获取公网IP
+func getIPfromNATPMP(gatewayAddr string, maxRetries int) (string, error) {
+	resp, err := routerRequest(gatewayAddr, []byte{0, 0}, maxRetries)
+	if err != nil {
+		return "", err
+	}
+	if len(resp) < 12 || resp[0] != 0 || resp[1] != 128 {
+		return "", errors.New("invalid NAT-PMP response")
+	}
+	if code := binary.BigEndian.Uint16(resp[2:4]); code != 0 {
+		return "", fmt.Errorf("NAT-PMP gateway returned result code %d", code)
+	}
+	return net.IP(resp[8:12]).String(), nil
+}
+
+// 通过 PCP (RFC 6887) 的 MAP 请求获取公网IP
+// PCP 没有单独查询外部地址的操作，因此申请一个短期映射，读取分配的外部地址后立即删除
+func getIPfromPCP(gatewayAddr string, maxRetries int) (string, error) {
+	conn, err := dialRouter(gatewayAddr)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	local := conn.LocalAddr().(*net.UDPAddr)
+	nonce := make([]byte, 12)
+	if _, err = rand.Read(nonce); err != nil {
+		return "", err
+	}
+	build := func(lifetime uint32) []byte {
+		req := make([]byte, 60)
+		req[0] = 2 // 版本号
+		req[1] = 1 // MAP
+		binary.BigEndian.PutUint32(req[4:8], lifetime)
+		copy(req[8:24], local.IP.To16())
+		copy(req[24:36], nonce)
+		req[36] = 17 // UDP
+		binary.BigEndian.PutUint16(req[40:42], uint16(local.Port))
+		copy(req[44:60], net.IPv4zero.To16())
+		return req
+	}
+	resp, err := routerExchange(conn, build(pcpMapLifetime), maxRetries)
+	if err != nil {
+		return "", err
+	}
+	if len(resp) < 60 || resp[0] != 2 || resp[1] != 0x81 {
+		return "", errors.New("invalid PCP response")
+	}
+	// 响应中的 nonce 必须与请求一致，否则可能是伪造或过期的响应
+	if !bytes.Equal(resp[24:36], nonce) {
+		return "", errors.New("PCP response nonce does not match the request")
+	}
+	if resp[3] != 0 {
+		return "", fmt.Errorf("PCP gateway returned result code %d", resp[3])
+	}
+	ip := net.IP(resp[44:60])
+	// 删除临时映射，失败不影响结果
+	_, _ = routerExchange(conn, build(0), 0)
+	if ip.To4() == nil {
+		return "", errors.New("PCP gateway returned a non-IPv4 address")
+	}
+	return ip.To4().String(), nil
+}
+
+func routerRequest(gatewayAddr string, req []byte, maxRetries int) ([]byte, error) {
+	conn, err := dialRouter(gatewayAddr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return routerExchange(conn, req, maxRetries)
+}
+
+func dialRouter(gatewayAddr string) (*net.UDPConn, error) {
+	if gatewayAddr == "" {
+		gw, err := defaultGateway()
+		if err != nil {
+			return nil, err
+		}
+		gatewayAddr = gw.String()
+	}
+	addr, err := net.ResolveUDPAddr("udp4", withDefaultPort(gatewayAddr, natpmpPort))
+	if err != nil {
+		return nil, err
+	}
+	return net.DialUDP("udp4", nil, addr)
+}
+
+// 发送请求并等待响应，超时后按照 RFC 6886 的方式以翻倍的间隔重传
+func routerExchange(conn *net.UDPConn, req []byte, maxRetries int) ([]byte, error) {
+	timeout := routerTimeout
+	buf := make([]byte, 1100)
+	for i := 0; i <= maxRetries; i++ {
+		if _, err := conn.Write(req); err != nil {
+			return nil, err
+		}
+		if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+			return nil, err
+		}
+		n, err := conn.Read(buf)
+		if err == nil {
+			return buf[:n], nil
+		}
+		if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+			return nil, err
+		}
+		timeout *= 2
+	}
+	return nil, errors.New("gateway did not respond")
+}
+
+// 读取系统默认网关，目前仅支持 Linux，其他系统请在配置文件中指定 GatewayAddr
+func defaultGateway() (net.IP, error) {
+	data, err := os.ReadFile("/proc/net/route")
+	if err != nil {
+		return nil, errors.New("unable to detect the default gateway, please set GatewayAddr in the config file")
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || fields[1] != "00000000" {
+			continue
+		}
+		raw, err := hex.DecodeString(fields[2])
+		if err != nil || len(raw) != 4 {
+			continue
+		}
+		// /proc/net/route 中的地址为小端序
+		return net.IPv4(raw[3], raw[2], raw[1], raw[0]), nil
+	}
+	return nil, errors.New("no default gateway found, please set GatewayAddr in the config file")
+}
+
+func withDefaultPort(addr string, port string) string {
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return addr
+	}
+	return net.JoinHostPort(addr, port)
+}
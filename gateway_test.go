@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+const testDescription = `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+<device>
+<deviceType>urn:schemas-upnp-org:device:InternetGatewayDevice:1</deviceType>
+<deviceList><device>
+<deviceType>urn:schemas-upnp-org:device:WANDevice:1</deviceType>
+<deviceList><device>
+<deviceType>urn:schemas-upnp-org:device:WANConnectionDevice:1</deviceType>
+<serviceList><service>
+<serviceType>urn:schemas-upnp-org:service:WANIPConnection:1</serviceType>
+<controlURL>/ctl/IPConn</controlURL>
+</service></serviceList>
+</device></deviceList>
+</device></deviceList>
+</device>
+</root>`
+
+// 模拟 UPnP 网关的设备描述文件和 GetExternalIPAddress
+func newFakeIGD(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(fakeIGDHandler())
+	t.Cleanup(server.Close)
+	return server
+}
+
+func fakeIGDHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/rootDesc.xml", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, testDescription)
+	})
+	mux.HandleFunc("/ctl/IPConn", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.Header.Get("SOAPAction") != `"urn:schemas-upnp-org:service:WANIPConnection:1#GetExternalIPAddress"` {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		io.WriteString(w, `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>
+<u:GetExternalIPAddressResponse xmlns:u="urn:schemas-upnp-org:service:WANIPConnection:1">
+<NewExternalIPAddress>203.0.113.7</NewExternalIPAddress>
+</u:GetExternalIPAddressResponse></s:Body></s:Envelope>`)
+	})
+	return mux
+}
+
+// 在本地启动一个 UDP 服务，用 handle 的返回值回复每个请求
+func newFakeUDPGateway(t *testing.T, handle func(req []byte) []byte) string {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if resp := handle(append([]byte(nil), buf[:n]...)); resp != nil {
+				conn.WriteTo(resp, addr)
+			}
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestGetIPfromUPnPDescription(t *testing.T) {
+	server := newFakeIGD(t)
+	ip, err := getIPfromUPnP(server.URL + "/rootDesc.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip != "203.0.113.7" {
+		t.Fatalf("got %q, want 203.0.113.7", ip)
+	}
+}
+
+func TestGetIPfromUPnPSSDP(t *testing.T) {
+	server := newFakeIGD(t)
+	addr := newFakeUDPGateway(t, func(req []byte) []byte {
+		if !strings.HasPrefix(string(req), "M-SEARCH * HTTP/1.1\r\n") {
+			return nil
+		}
+		return []byte("HTTP/1.1 200 OK\r\nST: " + igdDeviceType + "\r\nLocation: " + server.URL + "/rootDesc.xml\r\n\r\n")
+	})
+	ip, err := getIPfromUPnP(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip != "203.0.113.7" {
+		t.Fatalf("got %q, want 203.0.113.7", ip)
+	}
+}
+
+// 不是 localhost 的本机主机名，ProxyFromEnvironment 只对 localhost 和回环地址字面量直连
+func nonLoopbackHostname(t *testing.T) (string, string) {
+	name, err := os.Hostname()
+	if err != nil || name == "" || name == "localhost" {
+		t.Skip("no usable host name")
+	}
+	addrs, err := net.LookupIP(name)
+	if err != nil {
+		t.Skip("host name does not resolve: ", err)
+	}
+	for _, a := range addrs {
+		if a.To4() != nil {
+			return name, a.String()
+		}
+	}
+	t.Skip("host name has no ipv4 address")
+	return "", ""
+}
+
+// 在新的测试进程中重新运行当前测试，返回 true 表示已在子进程中
+// http.ProxyFromEnvironment 只在第一次调用时读取环境变量，需要在新进程中设置代理
+func inChildProcess(t *testing.T) bool {
+	if os.Getenv("QCIP_TEST_CHILD") == t.Name() {
+		return true
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^"+t.Name()+"$", "-test.v")
+	cmd.Env = append(os.Environ(), "QCIP_TEST_CHILD="+t.Name())
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+	if strings.Contains(string(out), "--- SKIP") {
+		t.Skipf("skipped in child process\n%s", out)
+	}
+	return false
+}
+
+func TestGetIPfromUPnPIgnoresProxy(t *testing.T) {
+	if !inChildProcess(t) {
+		return
+	}
+	name, addr := nonLoopbackHostname(t)
+	var hits atomic.Int32
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		http.Error(w, "proxied", http.StatusBadGateway)
+	}))
+	defer proxy.Close()
+	t.Setenv("HTTP_PROXY", proxy.URL)
+	t.Setenv("http_proxy", proxy.URL)
+	t.Setenv("NO_PROXY", "")
+	t.Setenv("no_proxy", "")
+	saved := httpClient
+	httpClient = &http.Client{Transport: &http.Transport{Proxy: http.ProxyFromEnvironment}}
+	defer func() { httpClient = saved }()
+
+	ln, err := net.Listen("tcp4", addr+":0")
+	if err != nil {
+		t.Skip("cannot listen on ", addr, ": ", err)
+	}
+	igd := &httptest.Server{Listener: ln, Config: &http.Server{Handler: fakeIGDHandler()}}
+	igd.Start()
+	defer igd.Close()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	ip, err := getIPfromUPnP("http://" + name + ":" + port + "/rootDesc.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip != "203.0.113.7" {
+		t.Fatalf("got %q, want 203.0.113.7", ip)
+	}
+	if n := hits.Load(); n != 0 {
+		t.Fatalf("proxy received %d request(s), want 0", n)
+	}
+}
+
+func TestGetIPfromNATPMP(t *testing.T) {
+	addr := newFakeUDPGateway(t, func(req []byte) []byte {
+		if len(req) != 2 || req[0] != 0 || req[1] != 0 {
+			return nil
+		}
+		resp := make([]byte, 12)
+		resp[1] = 128
+		binary.BigEndian.PutUint32(resp[4:8], 3600)
+		copy(resp[8:12], net.ParseIP("198.51.100.20").To4())
+		return resp
+	})
+	ip, err := getIPfromNATPMP(addr, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip != "198.51.100.20" {
+		t.Fatalf("got %q, want 198.51.100.20", ip)
+	}
+}
+
+func TestGetIPfromNATPMPResultCode(t *testing.T) {
+	addr := newFakeUDPGateway(t, func(req []byte) []byte {
+		resp := make([]byte, 12)
+		resp[1] = 128
+		binary.BigEndian.PutUint16(resp[2:4], 3) // Network Failure
+		return resp
+	})
+	if _, err := getIPfromNATPMP(addr, 0); err == nil || !strings.Contains(err.Error(), "result code 3") {
+		t.Fatalf("got %v, want result code error", err)
+	}
+}
+
+// 模拟 PCP 网关，corrupt 为 true 时返回错误的 nonce
+func newFakePCPGateway(t *testing.T, corrupt bool) (string, func() []uint32) {
+	var (
+		mu        sync.Mutex
+		lifetimes []uint32
+	)
+	addr := newFakeUDPGateway(t, func(req []byte) []byte {
+		if len(req) != 60 || req[0] != 2 || req[1] != 1 {
+			return nil
+		}
+		lifetime := binary.BigEndian.Uint32(req[4:8])
+		mu.Lock()
+		lifetimes = append(lifetimes, lifetime)
+		mu.Unlock()
+		resp := make([]byte, 60)
+		resp[0] = 2
+		resp[1] = 0x81
+		binary.BigEndian.PutUint32(resp[4:8], lifetime)
+		copy(resp[24:60], req[24:60])
+		if corrupt {
+			resp[24] ^= 0xff
+		}
+		copy(resp[44:60], net.ParseIP("192.0.2.55").To16())
+		return resp
+	})
+	return addr, func() []uint32 {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]uint32(nil), lifetimes...)
+	}
+}
+
+func TestGetIPfromPCP(t *testing.T) {
+	addr, lifetimes := newFakePCPGateway(t, false)
+	ip, err := getIPfromPCP(addr, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip != "192.0.2.55" {
+		t.Fatalf("got %q, want 192.0.2.55", ip)
+	}
+	// 第一个请求申请映射，第二个请求删除映射
+	if got := fmt.Sprint(lifetimes()); got != fmt.Sprintf("[%d 0]", pcpMapLifetime) {
+		t.Fatalf("lifetimes %s, want a map request followed by a delete", got)
+	}
+}
+
+func TestGetIPfromPCPNonceMismatch(t *testing.T) {
+	addr, _ := newFakePCPGateway(t, true)
+	if _, err := getIPfromPCP(addr, 0); err == nil || !strings.Contains(err.Error(), "nonce") {
+		t.Fatalf("got %v, want nonce mismatch error", err)
+	}
+}
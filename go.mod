@@ -3,14 +3,22 @@ module qcip
 go 1.21
 
 require (
+	filippo.io/age v1.1.1
+	github.com/BurntSushi/toml v1.3.2
 	github.com/alibabacloud-go/darabonba-openapi/v2 v2.0.5
 	github.com/alibabacloud-go/swas-open-20200601 v1.1.1
 	github.com/alibabacloud-go/tea v1.2.2
 	github.com/alibabacloud-go/tea-utils/v2 v2.0.4
+	github.com/spf13/cobra v1.8.0
+	github.com/spf13/pflag v1.0.5
 	github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common v1.0.866
 	github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/lighthouse v1.0.866
 	github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/vpc v1.0.866
+	golang.org/x/crypto v0.19.0
+	golang.org/x/term v0.17.0
+	gopkg.in/ini.v1 v1.67.0
 	gopkg.in/toast.v1 v1.0.0-20180812000517-0a84660828b2
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -22,11 +30,12 @@ require (
 	github.com/alibabacloud-go/tea-xml v1.1.3 // indirect
 	github.com/aliyun/credentials-go v1.3.2 // indirect
 	github.com/clbanning/mxj/v2 v2.7.0 // indirect
+	github.com/inconshreveable/mousetrap v1.1.0 // indirect
 	github.com/json-iterator/go v1.1.12 // indirect
 	github.com/modern-go/concurrent v0.0.0-20180306012644-bacd9c7ef1dd // indirect
 	github.com/modern-go/reflect2 v1.0.2 // indirect
 	github.com/nu7hatch/gouuid v0.0.0-20131221200532-179d4d0c4d8d // indirect
 	github.com/tjfoc/gmsm v1.4.1 // indirect
 	golang.org/x/net v0.21.0 // indirect
-	gopkg.in/ini.v1 v1.67.0 // indirect
+	golang.org/x/sys v0.17.0 // indirect
 )
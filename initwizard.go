@@ -0,0 +1,447 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	al_swas_open "github.com/alibabacloud-go/swas-open-20200601/client"
+	al_util "github.com/alibabacloud-go/tea-utils/v2/service"
+	"github.com/alibabacloud-go/tea/tea"
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
+	tchttp "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/http"
+	qc_lighthouse "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/lighthouse/v20200324"
+	qc_vpc "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/vpc/v20170312"
+	"golang.org/x/term"
+	"gopkg.in/yaml.v3"
+)
+
+const configSchemaURL = "https://raw.githubusercontent.com/cnlancehu/qcip/main/config.schema.json"
+
+// init 生成的配置文件，只包含填写了的字段
+type initConfig struct {
+	Schema              string   `json:"$schema,omitempty" yaml:"$schema,omitempty" toml:"-"`
+	MType               string   `json:"MType" yaml:"MType" toml:"MType"`
+	CredentialSource    string   `json:"CredentialSource,omitempty" yaml:"CredentialSource,omitempty" toml:"CredentialSource,omitempty"`
+	SecretId            string   `json:"SecretId,omitempty" yaml:"SecretId,omitempty" toml:"SecretId,omitempty"`
+	SecretKey           string   `json:"SecretKey,omitempty" yaml:"SecretKey,omitempty" toml:"SecretKey,omitempty"`
+	GetIPAPI            string   `json:"GetIPAPI" yaml:"GetIPAPI" toml:"GetIPAPI"`
+	InstanceId          string   `json:"InstanceId,omitempty" yaml:"InstanceId,omitempty" toml:"InstanceId,omitempty"`
+	InstanceRegion      string   `json:"InstanceRegion,omitempty" yaml:"InstanceRegion,omitempty" toml:"InstanceRegion,omitempty"`
+	SecurityGroupId     string   `json:"SecurityGroupId,omitempty" yaml:"SecurityGroupId,omitempty" toml:"SecurityGroupId,omitempty"`
+	SecurityGroupRegion string   `json:"SecurityGroupRegion,omitempty" yaml:"SecurityGroupRegion,omitempty" toml:"SecurityGroupRegion,omitempty"`
+	MaxRetries          int      `json:"MaxRetries" yaml:"MaxRetries" toml:"MaxRetries"`
+	Rules               []string `json:"Rules" yaml:"Rules" toml:"Rules"`
+}
+
+// 菜单中的选项，Value 为写入配置的值
+type initOption struct {
+	Value string
+	Label string
+}
+
+// 实例或安全组中的一条规则，只有带描述的规则才能被 qcip 匹配
+type initRule struct {
+	Description string
+	Detail      string
+}
+
+var stdinReader = bufio.NewReader(os.Stdin)
+
+// qcip init
+func initCommand(output string) {
+	if output == "" {
+		output = configCandidates()[0]
+	}
+	if strings.HasSuffix(output, encryptedExt) {
+		errOutput("Error arguments: init writes a plain config, run \033[33mqcip config encrypt\033[31m on it afterwards")
+		errExit()
+	}
+	if _, err := os.Stat(output); err == nil {
+		if !askYesNo(output+" already exists, overwrite it?", false) {
+			return
+		}
+	}
+	var err error
+	if apiTransport, err = newAPITransport(apiProxy); err != nil {
+		errOutput("Proxy error: " + err.Error())
+		errExit()
+	}
+
+	mType := chooseOption("Machine type", []initOption{
+		{"lh", "Tencent Cloud Lighthouse"},
+		{"cvm", "Tencent Cloud security group (CVM)"},
+		{"allh", "Alibaba Cloud Lighthouse"},
+	})
+	conf := initConfig{MType: mType, GetIPAPI: "IPCONF", MaxRetries: 3}
+	if configFormat(output) != "toml" {
+		conf.Schema = configSchemaURL
+	}
+	fmt.Printf("Leave SecretId empty to use environment variables, CLI profiles or the instance role\n")
+	conf.SecretId = askString("SecretId", "")
+	if conf.SecretId != "" {
+		conf.SecretKey = askSecret("SecretKey")
+	} else {
+		conf.CredentialSource = credentialChain
+	}
+	probe := Config{MType: mType, SecretId: conf.SecretId, SecretKey: conf.SecretKey, CredentialSource: conf.CredentialSource}
+	if probe.SecretId == "" {
+		probe.SecretId = os.Getenv(secretEnvFallback[mType]["SecretId"])
+		probe.SecretKey = os.Getenv(secretEnvFallback[mType]["SecretKey"])
+	}
+	cred, err := resolveCredential(probe)
+	if err != nil {
+		errOutput("Credential error:")
+		errDetail(err)
+		errExit()
+	}
+
+	var (
+		region string
+		target string
+		rules  []initRule
+	)
+	switch mType {
+	case "lh":
+		credential := common.NewTokenCredential(cred.SecretId, cred.SecretKey, cred.Token)
+		region = chooseOption("Region", initCheck(QClhListRegions(credential)))
+		target = chooseOption("Instance", initCheck(QClhListInstances(credential, region)))
+		for _, r := range QClhGetRules(credential, region, target) {
+			rules = append(rules, initRule{tea.StringValue(r.FirewallRuleDescription), tea.StringValue(r.Protocol) + " " + tea.StringValue(r.Port) + " from " + tea.StringValue(r.CidrBlock)})
+		}
+		conf.InstanceId, conf.InstanceRegion = target, region
+	case "cvm":
+		credential := common.NewTokenCredential(cred.SecretId, cred.SecretKey, cred.Token)
+		region = chooseOption("Region", initCheck(QCcvmListRegions(credential)))
+		target = chooseOption("Security group", initCheck(QCcvmListSecurityGroups(credential, region)))
+		if set := QCcvmGetRules(credential, target, region); set != nil {
+			for _, r := range set.Ingress {
+				rules = append(rules, initRule{tea.StringValue(r.PolicyDescription), tea.StringValue(r.Protocol) + " " + tea.StringValue(r.Port) + " from " + tea.StringValue(r.CidrBlock) + " " + tea.StringValue(r.Action)})
+			}
+		}
+		conf.SecurityGroupId, conf.SecurityGroupRegion = target, region
+	case "allh":
+		client, err := ALCreateClient(tea.String(cred.SecretId), tea.String(cred.SecretKey), tea.String(cred.Token))
+		if err != nil {
+			errOutput("Error while creating client for lighthouse:")
+			errDetail(err)
+			errExit()
+		}
+		region = chooseOption("Region", initCheck(ALlhListRegions(client)))
+		target = chooseOption("Instance", initCheck(ALlhListInstances(client, region)))
+		for _, r := range ALlhGetRules(client, region, target) {
+			rules = append(rules, initRule{tea.StringValue(r.Remark), tea.StringValue(r.RuleProtocol) + " " + tea.StringValue(r.Port) + " from " + tea.StringValue(r.SourceCidrIp)})
+		}
+		conf.InstanceId, conf.InstanceRegion = target, region
+	}
+	conf.Rules = chooseRules(rules)
+	conf.GetIPAPI = chooseOption("IP API", []initOption{
+		{"IPCONF", "ifconfig.co"},
+		{"LanceAPI", "api.lance.fun"},
+		{"IPIP", "myip.ipip.net"},
+		{"SB", "ip.sb"},
+		{"UPNP", "ask the router through UPnP"},
+		{"NATPMP", "ask the router through NAT-PMP"},
+		{"PCP", "ask the router through PCP"},
+	})
+
+	data, err := marshalInitConfig(conf, configFormat(output))
+	if err != nil {
+		errOutput("Error: " + err.Error())
+		errExit()
+	}
+	_, errs := parseConfig(data, configFormat(output), "", filepath.Dir(output))
+	if errs, _ = splitConfigErrors(errs); len(errs) > 0 {
+		errOutput("Config error:")
+		for _, e := range errs {
+			errOutput("  " + e.format(output))
+		}
+		errExit()
+	}
+	// 先删除已有文件，确保新文件以 0600 权限创建
+	os.Remove(output)
+	if err = os.WriteFile(output, data, 0600); err != nil {
+		errOutput("Error: " + err.Error())
+		errExit()
+	}
+	fmt.Printf("\033[1;32mConfig written to %s\033[0m\nRun \033[33mqcip -c %s\033[0m to apply it\n", output, output)
+}
+
+func initCheck(options []initOption, err error) []initOption {
+	if err != nil {
+		errOutput("Error while calling the cloud api:")
+		errDetail(err)
+		errExit()
+	}
+	if len(options) == 0 {
+		errOutput("Nothing found, check the region and the permissions of the key")
+		errExit()
+	}
+	return options
+}
+
+func marshalInitConfig(conf initConfig, format string) ([]byte, error) {
+	switch format {
+	case "yaml":
+		return yaml.Marshal(conf)
+	case "toml":
+		var buf bytes.Buffer
+		err := toml.NewEncoder(&buf).Encode(conf)
+		return buf.Bytes(), err
+	}
+	data, err := json.MarshalIndent(conf, "", "    ")
+	return append(data, '\n'), err
+}
+
+func readLine() string {
+	line, err := stdinReader.ReadString('\n')
+	if err != nil && line == "" {
+		errOutput("\nError: no input")
+		errExit()
+	}
+	return strings.TrimSpace(line)
+}
+
+func askString(prompt string, def string) string {
+	if def != "" {
+		fmt.Printf("%s [%s]: ", prompt, def)
+	} else {
+		fmt.Printf("%s: ", prompt)
+	}
+	if s := readLine(); s != "" {
+		return s
+	}
+	return def
+}
+
+// 在终端中输入时不回显
+func askSecret(prompt string) string {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return askString(prompt, "")
+	}
+	fmt.Printf("%s: ", prompt)
+	secret, err := term.ReadPassword(fd)
+	fmt.Println()
+	if err != nil {
+		errOutput("Error: " + err.Error())
+		errExit()
+	}
+	return strings.TrimSpace(string(secret))
+}
+
+func askYesNo(prompt string, def bool) bool {
+	hint := "y/N"
+	if def {
+		hint = "Y/n"
+	}
+	for {
+		fmt.Printf("%s [%s]: ", prompt, hint)
+		switch strings.ToLower(readLine()) {
+		case "":
+			return def
+		case "y", "yes":
+			return true
+		case "n", "no":
+			return false
+		}
+	}
+}
+
+// 显示菜单并返回所选项的值
+func chooseOption(prompt string, options []initOption) string {
+	fmt.Printf("\033[1m%s\033[0m\n", prompt)
+	for i, o := range options {
+		if o.Label != "" {
+			fmt.Printf("  %2d) %s  \033[90m%s\033[0m\n", i+1, o.Value, o.Label)
+		} else {
+			fmt.Printf("  %2d) %s\n", i+1, o.Value)
+		}
+	}
+	for {
+		fmt.Printf("Choose 1-%d: ", len(options))
+		s := readLine()
+		if n, err := strconv.Atoi(s); err == nil && n >= 1 && n <= len(options) {
+			return options[n-1].Value
+		}
+		for _, o := range options {
+			if s == o.Value {
+				return o.Value
+			}
+		}
+	}
+}
+
+// 选择需要修改的规则，可输入多个序号
+func chooseRules(rules []initRule) []string {
+	var described []initRule
+	for _, r := range rules {
+		if r.Description != "" {
+			described = append(described, r)
+		}
+	}
+	fmt.Printf("\033[1mRules to update\033[0m\n")
+	if len(described) < len(rules) {
+		fmt.Printf("\033[33m  %d rule(s) without a description are hidden, qcip matches rules by description\033[0m\n", len(rules)-len(described))
+	}
+	if len(described) == 0 {
+		errOutput("No rule with a description found, add a description to the rules in the console first")
+		errExit()
+	}
+	for i, r := range described {
+		fmt.Printf("  %2d) %s  \033[90m%s\033[0m\n", i+1, r.Description, r.Detail)
+	}
+	for {
+		fmt.Printf("Choose one or more, separated by commas: ")
+		var (
+			selected []string
+			err      error
+		)
+		for _, s := range strings.Split(readLine(), ",") {
+			n, e := strconv.Atoi(strings.TrimSpace(s))
+			if e != nil || n < 1 || n > len(described) {
+				err = errors.New("invalid choice")
+				break
+			}
+			if !containsString(selected, described[n-1].Description) {
+				selected = append(selected, described[n-1].Description)
+			}
+		}
+		if err == nil && len(selected) > 0 {
+			return selected
+		}
+	}
+}
+
+func QClhListRegions(credential *common.Credential) ([]initOption, error) {
+	client, _ := qc_lighthouse.NewClient(credential, "ap-guangzhou", QCClientProfile("lighthouse.tencentcloudapi.com"))
+	client.WithHttpTransport(apiTransport)
+	var response *qc_lighthouse.DescribeRegionsResponse
+	err := retrier.do(func() (err error) {
+		response, err = client.DescribeRegions(qc_lighthouse.NewDescribeRegionsRequest())
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	var options []initOption
+	for _, r := range response.Response.RegionSet {
+		options = append(options, initOption{tea.StringValue(r.Region), tea.StringValue(r.RegionName)})
+	}
+	return options, nil
+}
+
+func QClhListInstances(credential *common.Credential, region string) ([]initOption, error) {
+	client, _ := qc_lighthouse.NewClient(credential, region, QCClientProfile("lighthouse.tencentcloudapi.com"))
+	client.WithHttpTransport(apiTransport)
+	request := qc_lighthouse.NewDescribeInstancesRequest()
+	request.Offset = common.Int64Ptr(0)
+	request.Limit = common.Int64Ptr(100)
+	var response *qc_lighthouse.DescribeInstancesResponse
+	err := retrier.do(func() (err error) {
+		response, err = client.DescribeInstances(request)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	var options []initOption
+	for _, i := range response.Response.InstanceSet {
+		options = append(options, initOption{tea.StringValue(i.InstanceId), tea.StringValue(i.InstanceName) + " " + strings.Join(tea.StringSliceValue(i.PublicAddresses), ",")})
+	}
+	return options, nil
+}
+
+// 安全组所在的地域与云服务器一致，vpc 没有查询地域的接口，使用 cvm 的 DescribeRegions
+func QCcvmListRegions(credential *common.Credential) ([]initOption, error) {
+	client := common.NewCommonClient(credential, "ap-guangzhou", QCClientProfile("cvm.tencentcloudapi.com"))
+	client.WithHttpTransport(apiTransport)
+	request := tchttp.NewCommonRequest("cvm", "2017-03-12", "DescribeRegions")
+	response := tchttp.NewCommonResponse()
+	if err := retrier.do(func() error { return client.Send(request, response) }); err != nil {
+		return nil, err
+	}
+	var resp struct {
+		Response struct {
+			RegionSet []struct {
+				Region      string
+				RegionName  string
+				RegionState string
+			}
+		}
+	}
+	if err := json.Unmarshal(response.GetBody(), &resp); err != nil {
+		return nil, err
+	}
+	var options []initOption
+	for _, r := range resp.Response.RegionSet {
+		if r.RegionState == "AVAILABLE" {
+			options = append(options, initOption{r.Region, r.RegionName})
+		}
+	}
+	return options, nil
+}
+
+func QCcvmListSecurityGroups(credential *common.Credential, region string) ([]initOption, error) {
+	client, _ := qc_vpc.NewClient(credential, region, QCClientProfile("vpc.tencentcloudapi.com"))
+	client.WithHttpTransport(apiTransport)
+	request := qc_vpc.NewDescribeSecurityGroupsRequest()
+	request.Offset = common.StringPtr("0")
+	request.Limit = common.StringPtr("100")
+	var response *qc_vpc.DescribeSecurityGroupsResponse
+	err := retrier.do(func() (err error) {
+		response, err = client.DescribeSecurityGroups(request)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	var options []initOption
+	for _, g := range response.Response.SecurityGroupSet {
+		options = append(options, initOption{tea.StringValue(g.SecurityGroupId), tea.StringValue(g.SecurityGroupName)})
+	}
+	return options, nil
+}
+
+func ALlhListRegions(client *al_swas_open.Client) ([]initOption, error) {
+	var resp *al_swas_open.ListRegionsResponse
+	err := retrier.do(func() (err error) {
+		resp, err = client.ListRegionsWithOptions(&al_util.RuntimeOptions{})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	var options []initOption
+	for _, r := range resp.Body.Regions {
+		options = append(options, initOption{tea.StringValue(r.RegionId), tea.StringValue(r.LocalName)})
+	}
+	return options, nil
+}
+
+func ALlhListInstances(client *al_swas_open.Client, region string) ([]initOption, error) {
+	request := &al_swas_open.ListInstancesRequest{
+		RegionId: tea.String(region),
+		PageSize: tea.Int32(100),
+	}
+	var resp *al_swas_open.ListInstancesResponse
+	err := retrier.do(func() (err error) {
+		resp, err = client.ListInstancesWithOptions(request, &al_util.RuntimeOptions{})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	var options []initOption
+	for _, i := range resp.Body.Instances {
+		options = append(options, initOption{tea.StringValue(i.InstanceId), tea.StringValue(i.InstanceName) + " " + tea.StringValue(i.PublicIpAddress)})
+	}
+	return options, nil
+}
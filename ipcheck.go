@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"net/netip"
+	"strings"
+	"unicode/utf8"
+)
+
+// 不允许写入防火墙规则的地址段
+var reservedIPRanges = []struct {
+	prefix netip.Prefix
+	desc   string
+}{
+	{netip.MustParsePrefix("0.0.0.0/8"), "\"this network\" address"},
+	{netip.MustParsePrefix("10.0.0.0/8"), "private address (RFC 1918)"},
+	{netip.MustParsePrefix("100.64.0.0/10"), "carrier-grade NAT address (RFC 6598)"},
+	{netip.MustParsePrefix("127.0.0.0/8"), "loopback address"},
+	{netip.MustParsePrefix("169.254.0.0/16"), "link-local address"},
+	{netip.MustParsePrefix("172.16.0.0/12"), "private address (RFC 1918)"},
+	{netip.MustParsePrefix("192.0.0.0/24"), "IETF protocol assignment address"},
+	{netip.MustParsePrefix("192.0.2.0/24"), "documentation address (TEST-NET-1)"},
+	{netip.MustParsePrefix("192.88.99.0/24"), "6to4 relay anycast address"},
+	{netip.MustParsePrefix("192.168.0.0/16"), "private address (RFC 1918)"},
+	{netip.MustParsePrefix("198.18.0.0/15"), "benchmarking address"},
+	{netip.MustParsePrefix("198.51.100.0/24"), "documentation address (TEST-NET-2)"},
+	{netip.MustParsePrefix("203.0.113.0/24"), "documentation address (TEST-NET-3)"},
+	{netip.MustParsePrefix("224.0.0.0/4"), "multicast address"},
+	{netip.MustParsePrefix("240.0.0.0/4"), "reserved address"},
+}
+
+// 校验获取到的IP是否为可以写入防火墙规则的公网IPv4地址
+// allowRanges 中的地址段即使属于保留地址也会放行
+func checkIPaddr(ip string, allowRanges []string) error {
+	addr, err := netip.ParseAddr(strings.TrimSpace(ip))
+	if err != nil {
+		return errors.New("\"" + abbreviate(ip, 64) + "\" is not a valid ip address")
+	}
+	addr = addr.Unmap()
+	if !addr.Is4() {
+		return errors.New(addr.String() + " is not an ipv4 address")
+	}
+	for _, r := range allowRanges {
+		prefix, err := parseIPRange(r)
+		if err != nil {
+			return errors.New("AllowIPRanges entry " + r + " is incorrect")
+		}
+		if prefix.Contains(addr) {
+			return nil
+		}
+	}
+	for _, r := range reservedIPRanges {
+		if r.prefix.Contains(addr) {
+			return errors.New(addr.String() + " is a " + r.desc)
+		}
+	}
+	return nil
+}
+
+// 解析 CIDR 地址段，单个地址视为 /32
+func parseIPRange(r string) (netip.Prefix, error) {
+	if !strings.Contains(r, "/") {
+		addr, err := netip.ParseAddr(r)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	prefix, err := netip.ParsePrefix(r)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return prefix.Masked(), nil
+}
+
+// 按照 IPPrefix 将地址转换为网段，例如 1.2.3.4 和 24 得到 1.2.3.0/24
+// 未设置或为 32 时保持单个地址，与旧版本写入的规则一致
+func applyIPPrefix(ip string, bits int) string {
+	if bits == 0 || bits == 32 {
+		return ip
+	}
+	addr, err := netip.ParseAddr(strings.TrimSpace(ip))
+	if err != nil {
+		return ip
+	}
+	prefix, err := addr.Unmap().Prefix(bits)
+	if err != nil {
+		return ip
+	}
+	return prefix.String()
+}
+
+// 截断过长的内容，避免把整个错误页面输出到终端
+func abbreviate(s string, max int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if len(s) > max {
+		// 在字符边界截断，避免截断多字节字符
+		for max > 0 && !utf8.RuneStart(s[max]) {
+			max--
+		}
+		return s[:max] + "..."
+	}
+	return s
+}
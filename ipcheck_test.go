@@ -0,0 +1,90 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestCheckIPaddr(t *testing.T) {
+	tests := []struct {
+		name    string
+		ip      string
+		allow   []string
+		wantErr string
+	}{
+		{"public", "1.1.1.1", nil, ""},
+		{"surrounding whitespace", " 8.8.8.8\n", nil, ""},
+		{"ipv4-mapped ipv6", "::ffff:1.1.1.1", nil, ""},
+		{"rfc1918 10/8", "10.1.2.3", nil, "private address"},
+		{"rfc1918 172.16/12", "172.31.255.1", nil, "private address"},
+		{"rfc1918 192.168/16", "192.168.1.1", nil, "private address"},
+		{"cgnat", "100.64.0.1", nil, "carrier-grade NAT"},
+		{"cgnat upper bound", "100.127.255.254", nil, "carrier-grade NAT"},
+		{"outside cgnat", "100.128.0.1", nil, ""},
+		{"loopback", "127.0.0.1", nil, "loopback"},
+		{"link-local", "169.254.10.20", nil, "link-local"},
+		{"multicast", "224.0.0.251", nil, "multicast"},
+		{"test-net-1", "192.0.2.10", nil, "TEST-NET-1"},
+		{"test-net-2", "198.51.100.10", nil, "TEST-NET-2"},
+		{"test-net-3", "203.0.113.10", nil, "TEST-NET-3"},
+		{"this network", "0.0.0.0", nil, "this network"},
+		{"broadcast", "255.255.255.255", nil, "reserved address"},
+		{"html page", "<html><body>502 Bad Gateway</body></html>", nil, "is not a valid ip address"},
+		{"garbage", "your ip is 1.1.1.1", nil, "is not a valid ip address"},
+		{"empty", "", nil, "is not a valid ip address"},
+		{"ipv6", "2001:db8::1", nil, "is not an ipv4 address"},
+		{"allow single ip", "100.64.0.1", []string{"100.64.0.1"}, ""},
+		{"allow cidr", "10.1.2.3", []string{"192.168.0.0/16", "10.0.0.0/8"}, ""},
+		{"allow other ip", "100.64.0.2", []string{"100.64.0.1"}, "carrier-grade NAT"},
+		{"malformed allow entry", "1.1.1.1", []string{"10.0.0.0/8", "10.0.0.0/33"}, "AllowIPRanges entry 10.0.0.0/33 is incorrect"},
+		{"malformed allow ip", "10.1.2.3", []string{"10.1.2"}, "AllowIPRanges entry 10.1.2 is incorrect"},
+	}
+	for _, tt := range tests {
+		err := checkIPaddr(tt.ip, tt.allow)
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: got %v, want error containing %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestAbbreviate(t *testing.T) {
+	if got := abbreviate("  short \n text ", 64); got != "short text" {
+		t.Errorf("got %q", got)
+	}
+	if got := abbreviate(strings.Repeat("a", 70), 64); got != strings.Repeat("a", 64)+"..." {
+		t.Errorf("got %q", got)
+	}
+	// 第 64 个字节位于多字节字符中间
+	s := strings.Repeat("a", 62) + "错误页面"
+	got := abbreviate(s, 64)
+	if !utf8.ValidString(got) || got != strings.Repeat("a", 62)+"..." {
+		t.Errorf("got %q, want the cut on a rune boundary", got)
+	}
+}
+
+func TestApplyIPPrefix(t *testing.T) {
+	tests := []struct {
+		ip   string
+		bits int
+		want string
+	}{
+		{"203.0.113.77", 24, "203.0.113.0/24"},
+		{"203.0.113.77", 16, "203.0.0.0/16"},
+		{"203.0.113.77", 32, "203.0.113.77"},
+		{"203.0.113.77", 0, "203.0.113.77"},
+		{"::ffff:203.0.113.77", 24, "203.0.113.0/24"},
+		{"not an ip", 24, "not an ip"},
+	}
+	for _, tt := range tests {
+		if got := applyIPPrefix(tt.ip, tt.bits); got != tt.want {
+			t.Errorf("applyIPPrefix(%q, %d) = %q, want %q", tt.ip, tt.bits, got, tt.want)
+		}
+	}
+}
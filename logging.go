@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	logLevel      = "info" // --log-level
+	logFormat     = "text" // --log-format
+	logFile       string   // --log-file，为空时不写入文件
+	logMaxSize    = 10     // 日志文件轮转的大小，单位 MB
+	logMaxBackups = 3      // 保留的旧日志文件数量
+	logSinks      []string // --log-sink，stderr syslog journald
+
+	logger = newLogger(slog.LevelInfo, nil) // 默认只输出到控制台
+)
+
+var (
+	logLevels  = []string{"debug", "info", "warn", "error"}
+	logFormats = []string{"text", "json"}
+	logSinkSet = []string{"stderr", "syslog", "journald"}
+)
+
+// 系统日志的输出，仅在支持的系统上由 logsyslog.go 和 logjournald.go 设置
+var (
+	newSyslogHandler = func(level slog.Level) (slog.Handler, error) {
+		return nil, errors.New("syslog is not supported on " + goos)
+	}
+	newJournaldHandler = func(level slog.Level) (slog.Handler, error) {
+		return nil, errors.New("journald is not supported on " + goos)
+	}
+)
+
+// 按照 --log-* 参数创建日志
+func setupLogger() error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(logLevel)); err != nil || !containsString(logLevels, strings.ToLower(logLevel)) {
+		return errors.New("--log-level must be one of " + strings.Join(logLevels, ", "))
+	}
+	if !containsString(logFormats, logFormat) {
+		return errors.New("--log-format must be one of " + strings.Join(logFormats, ", "))
+	}
+	if logMaxSize <= 0 || logMaxBackups < 0 {
+		return errors.New("--log-max-size must be positive and --log-max-backups must not be negative")
+	}
+	var handlers []slog.Handler
+	newHandler := func(w io.Writer) slog.Handler {
+		opts := &slog.HandlerOptions{Level: level}
+		if logFormat == "json" {
+			return slog.NewJSONHandler(w, opts)
+		}
+		return slog.NewTextHandler(w, opts)
+	}
+	if logFile != "" {
+		f, err := openRotatingFile(logFile, int64(logMaxSize)<<20, logMaxBackups)
+		if err != nil {
+			return errors.New("failed to open log file: " + err.Error())
+		}
+		handlers = append(handlers, newHandler(f))
+	}
+	for _, sink := range logSinks {
+		switch sink {
+		case "stderr":
+			handlers = append(handlers, newHandler(os.Stderr))
+		case "syslog", "journald":
+			create := newSyslogHandler
+			if sink == "journald" {
+				create = newJournaldHandler
+			}
+			h, err := create(level)
+			if err != nil {
+				return errors.New("--log-sink " + sink + ": " + err.Error())
+			}
+			handlers = append(handlers, h)
+		default:
+			return errors.New("--log-sink must be one of " + strings.Join(logSinkSet, ", "))
+		}
+	}
+	var sinks slog.Handler
+	switch len(handlers) {
+	case 0:
+	case 1:
+		sinks = handlers[0]
+	default:
+		sinks = multiHandler(handlers)
+	}
+	logger = newLogger(level, sinks)
+	return nil
+}
+
+// 控制台和 --log-file --log-sink 共用一个日志，sinks 为 nil 时只输出到控制台
+func newLogger(level slog.Level, sinks slog.Handler) *slog.Logger {
+	return slog.New(redactHandler{inner: routeHandler{console: consoleHandler{level: level}, sinks: sinks}})
+}
+
+type logRouteKey struct{}
+
+// 只写入控制台或只写入日志的记录，errOutput 逐行输出到控制台，errExit 将汇总的错误写入日志
+var (
+	consoleOnly = context.WithValue(context.Background(), logRouteKey{}, "console")
+	sinksOnly   = context.WithValue(context.Background(), logRouteKey{}, "sinks")
+)
+
+func logRoute(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	route, _ := ctx.Value(logRouteKey{}).(string)
+	return route
+}
+
+// 将记录分发到控制台和日志
+type routeHandler struct {
+	console slog.Handler
+	sinks   slog.Handler
+}
+
+func (h routeHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	route := logRoute(ctx)
+	return (route != "sinks" && h.console.Enabled(ctx, level)) ||
+		(route != "console" && h.sinks != nil && h.sinks.Enabled(ctx, level))
+}
+
+func (h routeHandler) Handle(ctx context.Context, r slog.Record) error {
+	route := logRoute(ctx)
+	var errs []error
+	if route != "sinks" && h.console.Enabled(ctx, r.Level) {
+		errs = append(errs, h.console.Handle(ctx, r.Clone()))
+	}
+	if route != "console" && h.sinks != nil && h.sinks.Enabled(ctx, r.Level) {
+		errs = append(errs, h.sinks.Handle(ctx, r))
+	}
+	return errors.Join(errs...)
+}
+
+func (h routeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if h.sinks != nil {
+		h.sinks = h.sinks.WithAttrs(attrs)
+	}
+	h.console = h.console.WithAttrs(attrs)
+	return h
+}
+
+func (h routeHandler) WithGroup(name string) slog.Handler {
+	if h.sinks != nil {
+		h.sinks = h.sinks.WithGroup(name)
+	}
+	h.console = h.console.WithGroup(name)
+	return h
+}
+
+// 控制台输出，只显示信息本身，错误为红色，警告为黄色
+// 写入时才读取 os.Stdout，--output json 和 check 将其替换为标准错误后同样生效
+type consoleHandler struct {
+	level slog.Level
+}
+
+func (h consoleHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h consoleHandler) Handle(_ context.Context, r slog.Record) error {
+	msg := consoleMessage(r)
+	var err error
+	switch {
+	case r.Level >= slog.LevelError:
+		_, err = fmt.Fprintf(os.Stdout, "\033[31m%s\033[0m\n", msg)
+	case r.Level >= slog.LevelWarn:
+		_, err = fmt.Fprintf(os.Stdout, "\033[33m%s\033[0m\n", msg)
+	default:
+		_, err = fmt.Fprintln(os.Stdout, msg)
+	}
+	return err
+}
+
+// 日志中的信息保持不变，便于按信息归类，控制台按照模板将字段填入信息
+// {name} 替换为字段的值，{name:q} 替换为加引号的值
+var consoleMessages = map[string]string{
+	"Config warning":                 "Config warning: {warning}",
+	"Public IP":                      "Public IP: {ip} (from {source})",
+	"Rule not found":                 "Rule {rule:q} not found",
+	"Rule updated":                   "Rule {rule:q} updated from {old_ip} to {new_ip}",
+	"Rule up to date":                "Rule {rule:q} is up to date",
+	"Cannot fetch rules":             "Cannot fetch rules of {target}: {error}",
+	"Ignoring unreadable state file": "Ignoring unreadable state file {path}: {error}",
+	"Failed to save state file":      "Failed to save state file {path}: {error}",
+	"Retrying":                       "{reason}, retrying {attempt}/{max_retries} in {delay}",
+	"Started":                        "Started, checking every {interval}",
+	"Next run":                       "Next run in {interval}",
+	"Update check failed":            "Update check failed: {error}",
+	"Updated":                        "Updated to {to}",
+}
+
+var consolePlaceholder = regexp.MustCompile(`\{(\w+)(:q)?\}`)
+
+func consoleMessage(r slog.Record) string {
+	tmpl, ok := consoleMessages[r.Message]
+	if !ok {
+		return r.Message
+	}
+	attrs := map[string]string{}
+	r.Attrs(func(a slog.Attr) bool {
+		attrs[a.Key] = a.Value.Resolve().String()
+		return true
+	})
+	return consolePlaceholder.ReplaceAllStringFunc(tmpl, func(p string) string {
+		m := consolePlaceholder.FindStringSubmatch(p)
+		v := attrs[m[1]]
+		if m[2] != "" {
+			return strconv.Quote(v)
+		}
+		return v
+	})
+}
+
+func (h consoleHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+func (h consoleHandler) WithGroup(string) slog.Handler      { return h }
+
+// 同时写入多个输出
+type multiHandler []slog.Handler
+
+func (m multiHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range m {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m multiHandler) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+	for _, h := range m {
+		if h.Enabled(ctx, r.Level) {
+			if err := h.Handle(ctx, r.Clone()); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (m multiHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handlers := make(multiHandler, len(m))
+	for i, h := range m {
+		handlers[i] = h.WithAttrs(attrs)
+	}
+	return handlers
+}
+
+func (m multiHandler) WithGroup(name string) slog.Handler {
+	handlers := make(multiHandler, len(m))
+	for i, h := range m {
+		handlers[i] = h.WithGroup(name)
+	}
+	return handlers
+}
+
+// 超过 maxSize 时轮转的日志文件，旧文件依次命名为 path.1 path.2 ...
+type rotatingFile struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+func openRotatingFile(path string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	return r, r.open()
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.file, r.size = f, info.Size()
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *rotatingFile) rotate() error {
+	r.file.Close()
+	if r.maxBackups == 0 {
+		os.Remove(r.path)
+	} else {
+		os.Remove(r.path + "." + strconv.Itoa(r.maxBackups))
+		for i := r.maxBackups - 1; i >= 1; i-- {
+			os.Rename(r.path+"."+strconv.Itoa(i), r.path+"."+strconv.Itoa(i+1))
+		}
+		os.Rename(r.path, r.path+".1")
+	}
+	return r.open()
+}
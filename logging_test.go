@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+)
+
+// 将 os.Stdout 替换为管道，返回 fn 期间写入终端的内容
+func captureStdout(t *testing.T, fn func()) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := os.Stdout
+	os.Stdout = w
+	fn()
+	os.Stdout = saved
+	w.Close()
+	data, _ := io.ReadAll(r)
+	return string(data)
+}
+
+func TestLoggerRoutes(t *testing.T) {
+	var sink bytes.Buffer
+	l := newLogger(slog.LevelInfo, slog.NewTextHandler(&sink, nil))
+	console := captureStdout(t, func() {
+		l.Info("IP is the same", "target", "lh/ap-guangzhou/lhins-1")
+		l.Log(consoleOnly, slog.LevelError, "Config error")
+		l.Log(sinksOnly, slog.LevelError, "exit with error")
+		l.Debug("hidden")
+	})
+	if console != "IP is the same\n\033[31mConfig error\033[0m\n" {
+		t.Fatalf("console %q", console)
+	}
+	out := sink.String()
+	if strings.Count(out, "IP is the same") != 1 || !strings.Contains(out, "target=lh/ap-guangzhou/lhins-1") {
+		t.Fatalf("sink %q, want the info record once with its attrs", out)
+	}
+	if strings.Contains(out, "Config error") || !strings.Contains(out, "exit with error") || strings.Contains(out, "hidden") {
+		t.Fatalf("sink %q, want only sink routed records", out)
+	}
+}
+
+func TestLoggerLevelFiltersConsole(t *testing.T) {
+	l := newLogger(slog.LevelWarn, nil)
+	console := captureStdout(t, func() {
+		l.Info("Started")
+		l.Warn("Failed to save state")
+	})
+	if console != "\033[33mFailed to save state\033[0m\n" {
+		t.Fatalf("console %q, want only the warning", console)
+	}
+}
+
+// 日志中的信息保持不变，控制台按照模板显示完整的句子
+func TestConsoleMessageTemplate(t *testing.T) {
+	var sink bytes.Buffer
+	l := newLogger(slog.LevelDebug, slog.NewJSONHandler(&sink, nil))
+	console := captureStdout(t, func() {
+		l.Info("Rule updated", "target", "lh/ap-guangzhou/lhins-1", "rule", "ssh", "old_ip", "198.51.100.1", "new_ip", "203.0.113.7")
+		l.Warn("Retrying", "reason", "network error", "attempt", 1, "max_retries", 3, "delay", "500ms")
+		l.Warn("Failed to save state file", "path", "/tmp/state.json", "error", errors.New("permission denied"))
+		l.Info("Something new", "key", "value")
+	})
+	want := "Rule \"ssh\" updated from 198.51.100.1 to 203.0.113.7\n" +
+		"\033[33mnetwork error, retrying 1/3 in 500ms\033[0m\n" +
+		"\033[33mFailed to save state file /tmp/state.json: permission denied\033[0m\n" +
+		"Something new\n"
+	if console != want {
+		t.Fatalf("console %q, want %q", console, want)
+	}
+	var first map[string]interface{}
+	line, _, _ := strings.Cut(sink.String(), "\n")
+	if err := json.Unmarshal([]byte(line), &first); err != nil {
+		t.Fatal(err)
+	}
+	if first["msg"] != "Rule updated" || first["old_ip"] != "198.51.100.1" || first["new_ip"] != "203.0.113.7" || first["rule"] != "ssh" {
+		t.Fatalf("sink record %s", line)
+	}
+	if strings.Count(sink.String(), "198.51.100.1") != 1 {
+		t.Fatalf("value repeated in the sink record %s", line)
+	}
+}
+
+// 每个模板的字段都应能在调用处找到，模板中的信息不能带有字段的值
+func TestConsoleMessagesAreConstant(t *testing.T) {
+	for msg, tmpl := range consoleMessages {
+		if strings.ContainsAny(msg, "{}:") {
+			t.Errorf("message %q should be constant", msg)
+		}
+		if !consolePlaceholder.MatchString(tmpl) {
+			t.Errorf("template %q has no fields", tmpl)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const journaldSocket = "/run/systemd/journal/socket"
+
+func init() {
+	newJournaldHandler = func(level slog.Level) (slog.Handler, error) {
+		if _, err := os.Stat(journaldSocket); err != nil {
+			return nil, errors.New("journald socket " + journaldSocket + " not found")
+		}
+		conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journaldSocket, Net: "unixgram"})
+		if err != nil {
+			return nil, err
+		}
+		return &journaldHandler{conn: conn, level: level}, nil
+	}
+}
+
+// 使用 journald 原生协议写入日志，字段名转为大写，例如 target 写入 TARGET
+type journaldHandler struct {
+	conn   *net.UnixConn
+	level  slog.Level
+	attrs  []slog.Attr
+	prefix string // WithGroup 的字段名前缀
+}
+
+func (h *journaldHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *journaldHandler) Handle(_ context.Context, r slog.Record) error {
+	var buf bytes.Buffer
+	journaldField(&buf, "MESSAGE", r.Message)
+	journaldField(&buf, "PRIORITY", strconv.Itoa(journaldPriority(r.Level)))
+	journaldField(&buf, "SYSLOG_IDENTIFIER", "qcip")
+	for _, a := range h.attrs {
+		journaldAttr(&buf, "", a)
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		journaldAttr(&buf, h.prefix, a)
+		return true
+	})
+	_, err := h.conn.Write(buf.Bytes())
+	return err
+}
+
+func (h *journaldHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.attrs = append([]slog.Attr{}, h.attrs...)
+	for _, a := range attrs {
+		h2.attrs = append(h2.attrs, slog.Attr{Key: h.prefix + a.Key, Value: a.Value})
+	}
+	return &h2
+}
+
+func (h *journaldHandler) WithGroup(name string) slog.Handler {
+	h2 := *h
+	h2.prefix = h.prefix + name + "_"
+	return &h2
+}
+
+// syslog 优先级，与 journalctl -p 一致
+func journaldPriority(level slog.Level) int {
+	switch {
+	case level >= slog.LevelError:
+		return 3
+	case level >= slog.LevelWarn:
+		return 4
+	case level >= slog.LevelInfo:
+		return 6
+	}
+	return 7
+}
+
+func journaldAttr(buf *bytes.Buffer, prefix string, a slog.Attr) {
+	v := a.Value.Resolve()
+	if v.Kind() == slog.KindGroup {
+		for _, ga := range v.Group() {
+			journaldAttr(buf, prefix+a.Key+"_", ga)
+		}
+		return
+	}
+	var s string
+	switch v.Kind() {
+	case slog.KindTime:
+		s = v.Time().Format(time.RFC3339Nano)
+	default:
+		s = fmt.Sprint(v.Any())
+	}
+	journaldField(buf, prefix+a.Key, s)
+}
+
+// 写入一个字段，字段名只能包含大写字母、数字和下划线，包含换行的值使用二进制格式
+func journaldField(buf *bytes.Buffer, key string, value string) {
+	key = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			return r
+		}
+		return '_'
+	}, key)
+	key = strings.TrimLeft(key, "_")
+	if key == "" {
+		return
+	}
+	if !strings.Contains(value, "\n") {
+		buf.WriteString(key + "=" + value + "\n")
+		return
+	}
+	buf.WriteString(key + "\n")
+	binary.Write(buf, binary.LittleEndian, uint64(len(value)))
+	buf.WriteString(value + "\n")
+}
@@ -0,0 +1,72 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"log/syslog"
+	"strings"
+	"sync"
+)
+
+func init() {
+	newSyslogHandler = func(level slog.Level) (slog.Handler, error) {
+		w, err := syslog.New(syslog.LOG_INFO|syslog.LOG_DAEMON, "qcip")
+		if err != nil {
+			return nil, err
+		}
+		h := &syslogHandler{w: w, buf: &bytes.Buffer{}, mu: &sync.Mutex{}}
+		// 时间由 syslog 记录，消息中只保留级别以外的字段
+		h.inner = slog.NewTextHandler(h.buf, &slog.HandlerOptions{
+			Level: level,
+			ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+				if len(groups) == 0 && (a.Key == slog.TimeKey || a.Key == slog.LevelKey) {
+					return slog.Attr{}
+				}
+				return a
+			},
+		})
+		return h, nil
+	}
+}
+
+// 按日志级别写入对应优先级的 syslog
+type syslogHandler struct {
+	w     *syslog.Writer
+	inner slog.Handler
+	buf   *bytes.Buffer
+	mu    *sync.Mutex
+}
+
+func (h *syslogHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.inner.Enabled(ctx, level)
+}
+
+func (h *syslogHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.buf.Reset()
+	if err := h.inner.Handle(ctx, r); err != nil {
+		return err
+	}
+	msg := strings.TrimSuffix(h.buf.String(), "\n")
+	switch {
+	case r.Level >= slog.LevelError:
+		return h.w.Err(msg)
+	case r.Level >= slog.LevelWarn:
+		return h.w.Warning(msg)
+	case r.Level >= slog.LevelInfo:
+		return h.w.Info(msg)
+	}
+	return h.w.Debug(msg)
+}
+
+func (h *syslogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &syslogHandler{w: h.w, inner: h.inner.WithAttrs(attrs), buf: h.buf, mu: h.mu}
+}
+
+func (h *syslogHandler) WithGroup(name string) slog.Handler {
+	return &syslogHandler{w: h.w, inner: h.inner.WithGroup(name), buf: h.buf, mu: h.mu}
+}
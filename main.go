@@ -3,21 +3,21 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net"
 	"net/http"
 	"os"
 	"reflect"
 	"runtime"
 	"sort"
-	"strconv"
 	"strings"
 	"time"
 
 	// qcloud
 	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
-	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/errors"
 	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/profile"
 	qc_lighthouse "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/lighthouse/v20200324"
 	qc_vpc "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/vpc/v20170312"
@@ -34,13 +34,12 @@ var (
 	goos            = runtime.GOOS      // 程序运行的操作系统
 	goarch          = runtime.GOARCH    // 程序运行的操作系统架构
 	buildTime       = "buildTime"       // 程序编译时间
-	action          string              // 程序运行的行为
 	EnableWinNotify = false             // 是否启用 windows 通知
-	notifyHelpMsg   = ""                // 帮助信息中的通知信息
 	ua              = "qcip/" + version // 请求的 User-Agent
-	confPath        = "config.json"     // 默认配置文件路径
+	confPath        = ""                // -c 指定的配置文件路径，为空时按顺序查找
 	errMsgList      map[int]string      // 错误信息列表
 	errHandleTimes  = 0                 // 错误输出的次数
+	forceRun        = false             // 是否忽略缓存强制检查规则
 	httpClient      = &http.Client{
 		Timeout: time.Second * 10,
 		Transport: &http.Transport{
@@ -53,20 +52,6 @@ var (
 	ipAddr string                                     // 用户的IP地址
 )
 
-type Config struct {
-	MType               string
-	SecretId            string
-	SecretKey           string
-	GetIPAPI            string
-	InstanceId          string
-	InstanceRegion      string
-	SecurityGroupId     string
-	SecurityGroupRegion string
-	MaxRetries          string
-	EnableWinNotify     bool
-	Rules               []string
-}
-
 type IPIPResp struct {
 	IP string `json:"ip"`
 }
@@ -76,364 +61,313 @@ func init() {
 }
 
 func main() {
-	if len(os.Args) == 1 {
-		keyFunc()
-		return
-	} else {
-		for i, arg := range os.Args {
-			if arg == "-h" || arg == "--help" {
-				if action != "" {
-					errOutput("Error arguments: " + arg + " cannot be used with other arguments\nRun \033[33mqcip -h\033[31m for help")
-					return
-				}
-				action = "help"
-			} else if arg == "-v" || arg == "--version" {
-				if action != "" {
-					errOutput("Error arguments: " + arg + " cannot be used with other arguments\nRun \033[33mqcip -h\033[31m for help")
-					return
-				}
-				action = "version"
-			} else if arg == "-c" || arg == "--config" {
-				if action != "" {
-					errOutput("Error arguments: " + arg + " cannot be used with other arguments\nRun \033[33mqcip -h\033[31m for help")
-					return
-				}
-				action = "run"
-				if i == len(os.Args)-1 {
-					errOutput("Error arguments: config path not defined\nRun \033[33mqcip -h\033[31m for help")
-					return
-				}
-				confPath = os.Args[i+1]
-			} else if arg == "-n" || arg == "--winnotify" {
-				if goos == "windows" {
-					EnableWinNotify = true
-				} else {
-					errOutput("Error arguments: " + arg + " is only available on Windows")
-					return
-				}
-			} else if arg == "-ip" || arg == "--ipaddr" {
-				if i == len(os.Args)-1 {
-					errOutput("Error arguments: ip address not defined\nRun \033[33mqcip -h\033[31m for help")
-					return
-				}
-				ipAddr = os.Args[i+1]
-				ip := net.ParseIP(ipAddr)
-				if ip == nil {
-					errOutput("Error arguments: ip address is incorrect\nRun \033[33mqcip -h\033[31m for help")
-					return
-				}
-				if ip.To4() == nil {
-					errOutput("Error arguments: ip address is not ipv4\nRun \033[33mqcip -h\033[31m for help")
-					return
-				}
-			}
-		}
-		if action == "run" {
-			keyFunc()
-		} else if action == "version" {
-			if ipAddr != "" {
-				errOutput("Error arguments: you can only specify ip address when the program runs\nRun \033[33mqcip -h\033[31m for help")
-				return
-			}
-			if EnableWinNotify {
-				errOutput("Error arguments: you can only enable notifacation when the program runs\nRun \033[33mqcip -h\033[31m for help")
-				return
-			}
-			showVersionInfo()
-		} else if action == "help" {
-			if ipAddr != "" {
-				errOutput("Error arguments: you can only specify ip address when the program runs\nRun \033[33mqcip -h\033[31m for help")
-				return
-			}
-			if EnableWinNotify {
-				errOutput("Error arguments: you can only enable notifacation when the program runs\nRun \033[33mqcip -h\033[31m for help")
-				return
-			}
-			fmt.Printf("QCIP \033[1;32mv%s\033[0m\nUsuage:	qcip [options] [<value>]\nOptions:\n  -c  --config <path>\tSpecify the location of the configuration file and run\n  -v  --version\t\tShow version information\n  -h  --help\t\tShow this help page\n  -ip --ipaddr <ip>\tSpecify to use custom ip address%s\nExamples:\n  \033[33mqcip\033[0m\tRun the program with config.json\n  \033[33mqcip -c qcipconf.json\033[0m\tSpecify to use the configuration file qcipconf.json and run the program\n  \033[33mqcip -ip 1.1.1.1\033[0m\tSpecify to use ip 1.1.1.1 instead of autoget\nVisit our Github repo for more helps\n  https://github.com/cnlancehu/qcip\n", version, notifyHelpMsg)
-		} else if action == "" && EnableWinNotify {
-			keyFunc()
-		} else if action == "" && ipAddr != "" {
-			keyFunc()
-		} else {
-			errOutput("Error arguments: unknown arguments\nRun \033[33mqcip -h\033[31m for help")
-			return
-		}
-	}
+	os.Exit(execute(os.Args[1:]))
 }
 
 // 功能主函数
 func keyFunc() {
+	if runInterval > 0 {
+		runDaemon(runInterval)
+		os.Exit(0)
+	}
+	startReport("run")
+	runOnce()
+	report.finish()
+	os.Exit(0)
+}
+
+// 检查并修改一次规则
+func runOnce() {
 	fmt.Printf("QCIP \033[1;32mv%s\033[0m\n", version)
+	configData := prepareRun()
+	ip := resolveIP(configData)
+	statePath := configData.StateFile
+	if statePath == "" {
+		statePath = defaultStatePath()
+	}
+	cacheMaxAge := time.Duration(configData.CacheMaxAge)
+	state, err := loadState(statePath)
+	if err != nil {
+		logger.Warn("Ignoring unreadable state file", "path", statePath, "error", err)
+	}
+	key := targetKey(configData)
+	if !forceRun && state.upToDate(key, configData.Rules, ip, cacheMaxAge) {
+		logger.Info("IP is the same as last applied, skipped checking the rules", "target", key, "ip", ip)
+		if EnableWinNotify {
+			notify("QCIP | Success", "IP is the same", true)
+		}
+		report.addTarget(targetReport{Target: key, MType: configData.MType, Cached: true})
+		return
+	}
+	before := applyTarget(configData, ip)
+	state.record(key, configData.Rules, ip, before)
+	if err = saveState(statePath, state); err != nil {
+		logger.Warn("Failed to save state file", "path", statePath, "error", err)
+	}
+}
+
+// 查找并加载配置，按照配置设置重试和代理
+func prepareRun() Config {
+	confPath = findConfig()
 	configData := getConfig(confPath)
-	maxRetries, _ := strconv.Atoi(configData.MaxRetries)
-	if ipAddr == "" {
-		ipAddr = getIPaddr(configData.GetIPAPI, maxRetries)
+	retrier = newRetryPolicy(int(configData.MaxRetries), time.Duration(configData.RetryMaxElapsed))
+	var err error
+	if httpClient, err = newIPHTTPClient(configData.IPProxy); err != nil {
+		errOutput("Config error: IPProxy is incorrect: " + err.Error())
+		errExit()
+	}
+	apiProxy = configData.APIProxy
+	if apiTransport, err = newAPITransport(apiProxy); err != nil {
+		errOutput("Config error: APIProxy is incorrect: " + err.Error())
+		errExit()
+	}
+	return configData
+}
+
+// 获取并校验公网IP，使用 --ip 指定时不再获取，返回按 IPPrefix 转换后的地址
+func resolveIP(configData Config) string {
+	ip, source := ipAddr, "--ip"
+	if ip == "" {
+		ip = getIPaddr(configData.GetIPAPI, configData.GatewayAddr, int(configData.MaxRetries))
+		source = configData.GetIPAPI
+		if source == "" {
+			source = "IPCONF"
+		}
+	}
+	if err := checkIPaddr(ip, configData.AllowIPRanges); err != nil {
+		errOutput("IP address check failed: " + err.Error())
+		errOutput("  Add the address to AllowIPRanges in the config file if it is intended")
+		errExit()
 	}
+	ip = applyIPPrefix(ip, configData.IPPrefix)
+	logger.Info("Public IP", "ip", ip, "source", source)
+	report.setIP(ip, source)
+	return ip
+}
+
+// 将目标上匹配的规则修改为 ip，返回修改前目标上每条规则的地址
+func applyTarget(configData Config, ip string) map[string]string {
+	var before map[string]string
 	if configData.MType == "lh" {
-		QClhMain(configData, ipAddr)
+		before = QClhMain(configData, ip)
 	} else if configData.MType == "cvm" {
-		QCcvmMain(configData, ipAddr)
-	} else if configData.MType == "allh" {
-		ALlhMain(configData, ipAddr)
+		before = QCcvmMain(configData, ip)
+	} else {
+		before = ALlhMain(configData, ip)
+	}
+	key := targetKey(configData)
+	for _, name := range configData.Rules {
+		old, ok := before[name]
+		if !ok {
+			logger.Warn("Rule not found", "target", key, "rule", name)
+		} else if old != ip {
+			logger.Info("Rule updated", "target", key, "rule", name, "old_ip", old, "new_ip", ip)
+		} else {
+			logger.Debug("Rule up to date", "target", key, "rule", name, "ip", ip)
+		}
 	}
-	os.Exit(0)
+	report.addRun(configData, before, ip)
+	return before
 }
 
-// 腾讯轻量应用服务器主函数
-func QClhMain(configData Config, ip string) {
-	credential := common.NewCredential(
-		configData.SecretId,
-		configData.SecretKey,
+// 腾讯轻量应用服务器主函数，返回修改前每条规则的地址
+func QClhMain(configData Config, ip string) map[string]string {
+	cred := getCredential(configData)
+	credential := common.NewTokenCredential(
+		cred.SecretId,
+		cred.SecretKey,
+		cred.Token,
 	)
 	rules := QClhGetRules(credential, configData.InstanceRegion, configData.InstanceId)
+	before := make(map[string]string)
+	for _, r := range rules {
+		before[*r.FirewallRuleDescription] = *r.CidrBlock
+	}
 	res, needUpdate := QClhMatch(rules, ip, configData)
 	if needUpdate {
-		fmt.Printf("IP is different, start updating\n")
+		logger.Info("IP is different, start updating", "target", targetKey(configData))
 		QClhModifyRules(credential, configData.InstanceRegion, configData.InstanceId, res)
-		fmt.Printf("Successfully modified the firewall rules\n")
+		logger.Info("Successfully modified the firewall rules", "target", targetKey(configData))
 		if EnableWinNotify {
 			notify("QCIP | Success", "Successfully modified the firewall rules", true)
 		}
 	} else {
-		fmt.Printf("IP is the same\n")
+		logger.Info("IP is the same", "target", targetKey(configData))
 		if EnableWinNotify {
 			notify("QCIP | Success", "IP is the same", true)
 		}
 	}
+	return before
 }
 
-// 腾讯云服务器主函数
-func QCcvmMain(configData Config, ip string) {
-	credential := common.NewCredential(
-		configData.SecretId,
-		configData.SecretKey,
+// 腾讯云服务器主函数，返回修改前每条规则的地址
+func QCcvmMain(configData Config, ip string) map[string]string {
+	cred := getCredential(configData)
+	credential := common.NewTokenCredential(
+		cred.SecretId,
+		cred.SecretKey,
+		cred.Token,
 	)
 	rules := QCcvmGetRules(credential, configData.SecurityGroupId, configData.SecurityGroupRegion)
+	before := make(map[string]string)
+	for _, r := range rules.Ingress {
+		before[*r.PolicyDescription] = *r.CidrBlock
+	}
 	res, needUpdate := QCcvmMatch(rules, ip, configData)
 	if needUpdate {
-		fmt.Printf("IP is different, start updating\n")
+		logger.Info("IP is different, start updating", "target", targetKey(configData))
 		QCcvmModifyRules(credential, configData.SecurityGroupId, configData.SecurityGroupRegion, res)
-		fmt.Printf("Successfully modified the firewall rules\n")
+		logger.Info("Successfully modified the firewall rules", "target", targetKey(configData))
 		if EnableWinNotify {
 			notify("QCIP | Success", "Successfully modified the firewall rules", true)
 		}
 	} else {
-		fmt.Printf("IP is the same\n")
+		logger.Info("IP is the same", "target", targetKey(configData))
 		if EnableWinNotify {
 			notify("QCIP | Success", "IP is the same", true)
 		}
 	}
+	return before
 }
 
 func showVersionInfo() {
-	fmt.Printf("QCIP \033[1;32mv%s\033[0m | \033[1;33m%s %s\033[0m\nBuild time: %s\nChecking for update...", version, goos, goarch, buildTime)
-	req, _ := http.NewRequest("GET", "https://api.lance.fun/proj/qcip/version", nil)
-	req.Header.Set("User-Agent", ua)
-	resp, err := httpClient.Do(req)
-	if err != nil || (resp.StatusCode >= 400 && resp.StatusCode <= 599) {
-		fmt.Printf("\r\033[31mFailed to check updates\033[0m\n")
+	fmt.Printf("QCIP \033[1;32mv%s\033[0m | \033[1;33m%s %s\033[0m\nBuild time: %s\n", version, goos, goarch, buildTime)
+	if updateCheckDisabled() {
 		return
 	}
-	defer func(Body io.ReadCloser) {
-		err = Body.Close()
-		if err != nil {
-			fmt.Printf("\r\033[31mFailed to check updates\033[0m\n")
-			return
-		}
-	}(resp.Body)
-	errCheck := func() {
-		if err != nil {
-			fmt.Printf("\r\033[31mFailed to check updates\033[0m\n")
-			errExit()
-		}
-	}
-	latestverbyte, err := io.ReadAll(resp.Body)
-	errCheck()
-	latestVersion := string(latestverbyte)
-	currentVersion, err := strconv.Atoi(strings.Replace(version, ".", "", -1))
-	errCheck()
-	verlatest, err := strconv.Atoi(strings.Replace(latestVersion, ".", "", -1))
-	errCheck()
-	if verlatest > currentVersion {
-		fmt.Printf("\rNew version available: \033[1;32m%s\033[0m\nDownload it here: \n  https://github.com/cnlancehu/qcip/releases/tag/%s\n", latestVersion, latestVersion)
-	} else {
-		fmt.Printf("\r\033[1;32mYou are using the latest version\033[0m\n")
-	}
-}
-
-// 读取配置文件
-func getConfig(confPath string) Config {
-	config, err := os.ReadFile(confPath)
+	channel, err := resolveUpdateChannel()
 	if err != nil {
-		if os.IsNotExist(err) {
-			errOutput("Config error: config file " + confPath + " does not exist")
-			errExit()
-		}
-		errOutput("Config error: " + err.Error())
-		errExit()
-	}
-	var configData Config
-	if !json.Valid(config) {
-		errOutput("Config error: config file is not valid json")
+		errOutput("Failed to check updates:")
+		errDetail(err)
 		errExit()
 	}
-	err = json.Unmarshal(config, &configData)
+	fmt.Printf("Checking for update...")
+	latest, newer, err := checkUpdate(channel)
 	if err != nil {
-		decodeErr := json.Unmarshal(config, &configData)
-		if decodeErr != nil {
-			errOutput("Config error: config file format is incorrect")
-			errExit()
-		}
-		errOutput("Config error: " + err.Error())
-		errExit()
-	}
-	if configData.EnableWinNotify {
-		if goos != "windows" {
-			errOutput("Config error: EnableWinNotify is only available on Windows")
-			errExit()
-		}
-		EnableWinNotify = true
+		fmt.Printf("\r\033[31mFailed to check updates\033[0m\n")
+		logger.Debug("Update check failed", "endpoint", updateEndpoint(), "error", err)
+		return
 	}
-	var requiredKeys []string
-	if configData.MType == "lh" {
-		requiredKeys = []string{"SecretId", "SecretKey", "InstanceId", "InstanceRegion", "Rules"}
-	} else if configData.MType == "cvm" {
-		requiredKeys = []string{"SecretId", "SecretKey", "SecurityGroupId", "SecurityGroupRegion", "Rules"}
-	} else if configData.MType == "allh" {
-		requiredKeys = []string{"SecretId", "SecretKey", "InstanceId", "InstanceRegion", "Rules"}
+	if newer {
+		fmt.Printf("\rNew version available: \033[1;32m%s\033[0m\nDownload it here: \n  %s\n", latest.TagName, latest.HTMLURL)
 	} else {
-		if configData.MType == "" {
-			errOutput("Config error: machine type is empty")
-			errExit()
-		} else {
-			errOutput("Config error: machine type " + configData.MType + " is incorrect")
-			errExit()
-		}
+		fmt.Printf("\r\033[1;32mYou are using the latest %s version\033[0m\n", channel)
 	}
-	checkPassing := true
-	for _, key := range requiredKeys {
-		if _, ok := reflect.TypeOf(configData).FieldByName(key); !ok {
-			checkPassing = false
-		}
-		if reflect.ValueOf(configData).FieldByName(key).String() == "" {
-			checkPassing = false
-		}
-		if reflect.ValueOf(configData).FieldByName(key).String() == key {
-			checkPassing = false
-		}
-	}
-	if !checkPassing {
-		errOutput("Config error:")
-		for _, key := range requiredKeys {
-			if _, ok := reflect.TypeOf(configData).FieldByName(key); !ok {
-				errOutput("  " + key + " not found")
-			} else if reflect.ValueOf(configData).FieldByName(key).String() == "" {
-				errOutput("  " + key + " is empty")
-			} else if reflect.ValueOf(configData).FieldByName(key).String() == key {
-				errOutput("  " + key + " is incorrect")
-			}
+}
+
+// 获取自身公网IP，出错时退出
+func getIPaddr(api string, gatewayAddr string, maxRetries int) string {
+	ip, err := fetchIPaddr(api, gatewayAddr, maxRetries)
+	if err != nil {
+		if api == "UPNP" || api == "NATPMP" || api == "PCP" {
+			errOutput("Router calling error: " + api)
+			errOutput("  Error detail: " + err.Error())
+		} else {
+			errOutput("IP API calling error:")
+			errOutput("  Error detail: " + err.Error())
+			errOutput("IP API call failed, exiting...")
 		}
 		errExit()
 	}
-	fmt.Printf("Config loaded\n")
-	return configData
+	return ip
 }
 
-// 获取自身公网IP
-func getIPaddr(api string, maxRetries int) string {
-	if maxRetries < 0 || maxRetries > 10 {
-		errOutput("Config error: maxRetries should be an integer greater than or equal to 0 and less than or equal to 10")
-	}
-	fetchApi := func(apiURL string) []byte {
-		var (
-			req    *http.Request
-			resp   *http.Response
-			err    error
-			failed bool
-		)
-		for i := 0; i <= maxRetries; i++ {
-			req, _ = http.NewRequest("GET", apiURL, nil)
-			req.Header.Set("User-Agent", ua)
-			resp, err = httpClient.Do(req)
-			if err != nil || (resp.StatusCode >= 400 && resp.StatusCode <= 599) {
-				failed = true
-				if i == 0 {
-					errOutput("IP API calling error:")
-					errOutput("  Error detail: " + err.Error())
-					continue
-				}
-				if maxRetries != 0 {
-					fmt.Printf("\r\033[31m%s\033[0m", "    retrying "+strconv.Itoa(i)+"/"+strconv.Itoa(maxRetries)+" time")
-					time.Sleep(1 * time.Second)
-				}
-			} else {
-				break
-			}
-		}
-		if failed {
-			if maxRetries != 0 {
-				fmt.Printf("\n")
-				errOutput("IP API call failed " + fmt.Sprint(maxRetries) + " times, exiting...")
-			} else {
-				errOutput("IP API call failed, exiting...")
+func fetchIPaddr(api string, gatewayAddr string, maxRetries int) (string, error) {
+	fetchApi := func(apiURL string) ([]byte, error) {
+		var respcontent []byte
+		err := retrier.do(func() error {
+			req, err := http.NewRequest("GET", apiURL, nil)
+			if err != nil {
+				return err
 			}
-			errExit()
-		}
-		defer func(Body io.ReadCloser) {
-			err := Body.Close()
+			req.Header.Set("User-Agent", ua)
+			resp, err := httpClient.Do(req)
 			if err != nil {
-				errOutput("IP API calling error")
-				errOutput("  Error detail: " + err.Error())
-				errExit()
-				return
+				return err
 			}
-		}(resp.Body)
-		respcontent, err := io.ReadAll(resp.Body)
-		if err != nil {
-			errOutput("IP API calling error: " + err.Error())
-			errExit()
-		}
-		return respcontent
+			defer resp.Body.Close()
+			if resp.StatusCode >= 400 && resp.StatusCode <= 599 {
+				return &httpStatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+			}
+			respcontent, err = io.ReadAll(resp.Body)
+			return err
+		})
+		return respcontent, err
+	}
+	fetchText := func(apiURL string) (string, error) {
+		respcontent, err := fetchApi(apiURL)
+		return strings.TrimSpace(string(respcontent)), err
 	}
 	if api == "LanceAPI" {
-		return string(fetchApi("https://api.lance.fun/ip"))
+		return fetchText("https://api.lance.fun/ip")
 	} else if api == "IPIP" {
-		var r IPIPResp
-		err := json.Unmarshal(fetchApi("https://myip.ipip.net/ip"), &r)
+		respcontent, err := fetchApi("https://myip.ipip.net/ip")
 		if err != nil {
-			errOutput("IP API calling error: " + err.Error())
-			errOutput("  Error detail: " + err.Error())
-			errExit()
+			return "", err
 		}
-		return r.IP
+		var r IPIPResp
+		if err = json.Unmarshal(respcontent, &r); err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(r.IP), nil
 	} else if api == "SB" {
-		return strings.TrimRight(string(fetchApi("https://api-ipv4.ip.sb/ip")), "\n")
+		return fetchText("https://api-ipv4.ip.sb/ip")
 	} else if api == "IPCONF" || api == "" {
-		return strings.TrimSpace(string(fetchApi("https://ifconfig.co/ip")))
-	} else {
-		errOutput("IP API calling error: unknown API " + api)
-		errExit()
-		return ""
-	}
+		return fetchText("https://ifconfig.co/ip")
+	} else if api == "UPNP" {
+		var (
+			ip  string
+			err error
+		)
+		err = retrier.do(func() error {
+			ip, err = getIPfromUPnP(gatewayAddr)
+			return err
+		})
+		return ip, err
+	} else if api == "NATPMP" {
+		return getIPfromNATPMP(gatewayAddr, maxRetries)
+	} else if api == "PCP" {
+		return getIPfromPCP(gatewayAddr, maxRetries)
+	}
+	return "", errors.New("unknown API " + api)
+}
+
+// 腾讯云客户端配置，重试由 retrier 统一处理
+func QCClientProfile(endpoint string) *profile.ClientProfile {
+	cpf := profile.NewClientProfile()
+	cpf.NetworkFailureMaxRetries = 0
+	cpf.RateLimitExceededMaxRetries = 0
+	cpf.HttpProfile.Endpoint = endpoint
+	return cpf
 }
 
 // 腾讯云轻量应用服务器部分
 func QClhGetRules(credential *common.Credential, InstanceRegion string, InstanceId string) []*qc_lighthouse.FirewallRuleInfo {
-	cpf := profile.NewClientProfile()
-	cpf.NetworkFailureMaxRetries = 3
-	cpf.HttpProfile.Endpoint = "lighthouse.tencentcloudapi.com"
-	client, _ := qc_lighthouse.NewClient(credential, InstanceRegion, cpf)
+	rules, err := QClhFetchRules(credential, InstanceRegion, InstanceId)
+	if err != nil {
+		errOutput("Error while fetching rules for lighthouse:")
+		errDetail(err)
+		errExit()
+	}
+	return rules
+}
+
+func QClhFetchRules(credential *common.Credential, InstanceRegion string, InstanceId string) ([]*qc_lighthouse.FirewallRuleInfo, error) {
+	client, _ := qc_lighthouse.NewClient(credential, InstanceRegion, QCClientProfile("lighthouse.tencentcloudapi.com"))
+	client.WithHttpTransport(apiTransport)
 	request := qc_lighthouse.NewDescribeFirewallRulesRequest()
 	request.InstanceId = common.StringPtr(InstanceId)
 	request.Offset = common.Int64Ptr(0)
 	request.Limit = common.Int64Ptr(100)
-	response, err := client.DescribeFirewallRules(request)
-	if _, ok := err.(*errors.TencentCloudSDKError); ok {
-		errOutput("Error while fetching rules for lighthouse:")
-		errOutput("  " + err.Error())
-		errExit()
+	var response *qc_lighthouse.DescribeFirewallRulesResponse
+	err := retrier.do(func() (err error) {
+		response, err = client.DescribeFirewallRules(request)
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
-	return response.Response.FirewallRuleSet
+	return response.Response.FirewallRuleSet, nil
 }
 
 func QClhMatch(rules []*qc_lighthouse.FirewallRuleInfo, ip string, config Config) ([]*qc_lighthouse.FirewallRuleInfo, bool) {
@@ -464,17 +398,18 @@ func QClhModifyRules(credential *common.Credential, InstanceRegion string, Insta
 			FirewallRuleDescription: common.StringPtr(*rules[i].FirewallRuleDescription),
 		}
 	}
-	cpf := profile.NewClientProfile()
-	cpf.NetworkFailureMaxRetries = 3
-	cpf.HttpProfile.Endpoint = "lighthouse.tencentcloudapi.com"
-	client, _ := qc_lighthouse.NewClient(credential, InstanceRegion, cpf)
+	client, _ := qc_lighthouse.NewClient(credential, InstanceRegion, QCClientProfile("lighthouse.tencentcloudapi.com"))
+	client.WithHttpTransport(apiTransport)
 	request := qc_lighthouse.NewModifyFirewallRulesRequest()
 	request.InstanceId = common.StringPtr(InstanceId)
 	request.FirewallRules = ptrRules
-	_, err := client.ModifyFirewallRules(request)
-	if _, ok := err.(*errors.TencentCloudSDKError); ok {
+	err := retrier.do(func() error {
+		_, err := client.ModifyFirewallRules(request)
+		return err
+	})
+	if err != nil {
 		errOutput("Error while modifying rules for lighthouse:")
-		errOutput("  " + err.Error())
+		errDetail(err)
 		errExit()
 		return
 	}
@@ -482,19 +417,29 @@ func QClhModifyRules(credential *common.Credential, InstanceRegion string, Insta
 
 // 腾讯云云服务器安全组部分
 func QCcvmGetRules(credential *common.Credential, SecurityGroupId string, SecurityGroupRegion string) *qc_vpc.SecurityGroupPolicySet {
-	cpf := profile.NewClientProfile()
-	cpf.NetworkFailureMaxRetries = 3
-	cpf.HttpProfile.Endpoint = "vpc.tencentcloudapi.com"
-	client, _ := qc_vpc.NewClient(credential, SecurityGroupRegion, cpf)
-	request := qc_vpc.NewDescribeSecurityGroupPoliciesRequest()
-	request.SecurityGroupId = common.StringPtr(SecurityGroupId)
-	response, err := client.DescribeSecurityGroupPolicies(request)
-	if _, ok := err.(*errors.TencentCloudSDKError); ok {
+	rules, err := QCcvmFetchRules(credential, SecurityGroupId, SecurityGroupRegion)
+	if err != nil {
 		errOutput("Error while fetching rules for security group:")
-		errOutput("  " + err.Error())
+		errDetail(err)
 		errExit()
 	}
-	return response.Response.SecurityGroupPolicySet
+	return rules
+}
+
+func QCcvmFetchRules(credential *common.Credential, SecurityGroupId string, SecurityGroupRegion string) (*qc_vpc.SecurityGroupPolicySet, error) {
+	client, _ := qc_vpc.NewClient(credential, SecurityGroupRegion, QCClientProfile("vpc.tencentcloudapi.com"))
+	client.WithHttpTransport(apiTransport)
+	request := qc_vpc.NewDescribeSecurityGroupPoliciesRequest()
+	request.SecurityGroupId = common.StringPtr(SecurityGroupId)
+	var response *qc_vpc.DescribeSecurityGroupPoliciesResponse
+	err := retrier.do(func() (err error) {
+		response, err = client.DescribeSecurityGroupPolicies(request)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return response.Response.SecurityGroupPolicySet, nil
 }
 
 func QCcvmMatch(rules *qc_vpc.SecurityGroupPolicySet, ip string, config Config) (*qc_vpc.SecurityGroupPolicySet, bool) {
@@ -515,17 +460,18 @@ func QCcvmMatch(rules *qc_vpc.SecurityGroupPolicySet, ip string, config Config)
 }
 
 func QCcvmModifyRules(credential *common.Credential, SecurityGroupId string, SecurityGroupRegion string, rules *qc_vpc.SecurityGroupPolicySet) {
-	cpf := profile.NewClientProfile()
-	cpf.NetworkFailureMaxRetries = 3
-	cpf.HttpProfile.Endpoint = "vpc.tencentcloudapi.com"
-	client, _ := qc_vpc.NewClient(credential, SecurityGroupRegion, cpf)
+	client, _ := qc_vpc.NewClient(credential, SecurityGroupRegion, QCClientProfile("vpc.tencentcloudapi.com"))
+	client.WithHttpTransport(apiTransport)
 	request := qc_vpc.NewModifySecurityGroupPoliciesRequest()
 	request.SecurityGroupId = common.StringPtr(SecurityGroupId)
 	request.SecurityGroupPolicySet = QCcvmProcessRules(rules)
-	_, err := client.ModifySecurityGroupPolicies(request)
-	if _, ok := err.(*errors.TencentCloudSDKError); ok {
+	err := retrier.do(func() error {
+		_, err := client.ModifySecurityGroupPolicies(request)
+		return err
+	})
+	if err != nil {
 		errOutput("Error while modifying rules for security group:")
-		errOutput("  " + err.Error())
+		errDetail(err)
 		errExit()
 	}
 }
@@ -565,52 +511,84 @@ func replaceEmptyValue(ptrRules interface{}) interface{} {
 }
 
 // 阿里云部分
-func ALCreateClient(accessKeyId *string, accessKeySecret *string) (_result *al_swas_open.Client, _err error) {
+func ALCreateClient(accessKeyId *string, accessKeySecret *string, securityToken *string) (_result *al_swas_open.Client, _err error) {
 	config := &al_openapi.Config{
 		// 必填，您的 AccessKey ID
 		AccessKeyId: accessKeyId,
 		// 必填，您的 AccessKey Secret
 		AccessKeySecret: accessKeySecret,
 	}
+	// 使用 STS 临时凭证时需要填写
+	if tea.StringValue(securityToken) != "" {
+		config.SecurityToken = securityToken
+	}
 	// Endpoint 请参考 https://api.aliyun.com/product/SWAS-OPEN
 	config.Endpoint = tea.String("swas.cn-hongkong.aliyuncs.com")
+	if _err = ALSetProxy(config, apiProxy); _err != nil {
+		return nil, _err
+	}
 	_result = &al_swas_open.Client{}
 	_result, _err = al_swas_open.NewClient(config)
 	return _result, _err
 }
 
-// 阿里云轻量应用服务器主函数
-func ALlhMain(configData Config, ip string) {
-	client, _ := ALCreateClient(tea.String(configData.SecretId), tea.String(configData.SecretKey))
+// 阿里云轻量应用服务器主函数，返回修改前每条规则的地址
+func ALlhMain(configData Config, ip string) map[string]string {
+	cred := getCredential(configData)
+	client, err := ALCreateClient(tea.String(cred.SecretId), tea.String(cred.SecretKey), tea.String(cred.Token))
+	if err != nil {
+		errOutput("Error while creating client for lighthouse:")
+		errDetail(err)
+		errExit()
+	}
 
 	rules := ALlhGetRules(client, configData.InstanceRegion, configData.InstanceId)
+	before := make(map[string]string)
+	for _, r := range rules {
+		before[*r.Remark] = *r.SourceCidrIp
+	}
 	res, needUpdate := ALlhMatch(rules, ip, configData)
 	if needUpdate {
-		fmt.Printf("IP is different, start updating\n")
+		logger.Info("IP is different, start updating", "target", targetKey(configData))
 		ALlhModifyRules(client, configData.InstanceRegion, configData.InstanceId, res)
-		fmt.Printf("Successfully modified the firewall rules\n")
+		logger.Info("Successfully modified the firewall rules", "target", targetKey(configData))
 		if EnableWinNotify {
 			notify("QCIP | Success", "Successfully modified the firewall rules", true)
 		}
 	} else {
-		fmt.Printf("IP is the same\n")
+		logger.Info("IP is the same", "target", targetKey(configData))
 		if EnableWinNotify {
 			notify("QCIP | Success", "IP is the same", true)
 		}
 	}
+	return before
 }
 
 func ALlhGetRules(client *al_swas_open.Client, InstanceRegion string, InstanceId string) []*al_swas_open.ListFirewallRulesResponseBodyFirewallRules {
+	rules, err := ALlhFetchRules(client, InstanceRegion, InstanceId)
+	if err != nil {
+		errOutput("Error while fetching rules for lighthouse:")
+		errDetail(err)
+		errExit()
+	}
+	return rules
+}
+
+func ALlhFetchRules(client *al_swas_open.Client, InstanceRegion string, InstanceId string) ([]*al_swas_open.ListFirewallRulesResponseBodyFirewallRules, error) {
 	listFirewallRulesRequest := &al_swas_open.ListFirewallRulesRequest{
 		RegionId:   tea.String(InstanceRegion),
 		InstanceId: tea.String(InstanceId),
 	}
 	runtime := &al_util.RuntimeOptions{}
-	resp, err := client.ListFirewallRulesWithOptions(listFirewallRulesRequest, runtime)
+	var resp *al_swas_open.ListFirewallRulesResponse
+	err := retrier.do(func() (err error) {
+		resp, err = client.ListFirewallRulesWithOptions(listFirewallRulesRequest, runtime)
+		return err
+	})
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	return resp.Body.FirewallRules
+	return resp.Body.FirewallRules, nil
 }
 
 func ALlhMatch(rules []*al_swas_open.ListFirewallRulesResponseBodyFirewallRules, ip string, config Config) ([]*al_swas_open.ListFirewallRulesResponseBodyFirewallRules, bool) {
@@ -644,21 +622,34 @@ func ALlhModifyRules(client *al_swas_open.Client, InstanceRegion string, Instanc
 			Remark:       tea.String(*rule.Remark),
 		}
 		runtime := &al_util.RuntimeOptions{}
-		_, err := client.ModifyFirewallRuleWithOptions(modifyFirewallRuleRequest, runtime)
+		err := retrier.do(func() error {
+			_, err := client.ModifyFirewallRuleWithOptions(modifyFirewallRuleRequest, runtime)
+			return err
+		})
 		if err != nil {
-			errOutput("Error while fetching rules for lighthouse:")
-			errOutput("  " + err.Error())
+			errOutput("Error while modifying rules for lighthouse:")
+			errDetail(err)
 			errExit()
 		}
 	}
 }
 
 func errOutput(errMsg string) {
+	errMsg = redact(errMsg)
 	if EnableWinNotify {
 		errHandleTimes++
 		errMsgList[errHandleTimes] = errMsg
 	}
-	fmt.Printf("\033[31m%s\033[0m\n", errMsg)
+	pendingErr = append(pendingErr, strings.TrimSpace(errMsg))
+	logger.Log(consoleOnly, slog.LevelError, errMsg)
+}
+
+// 输出错误详情，并记录云服务商返回的错误码
+func errDetail(err error) {
+	if code := apiErrorCode(err); code != "" {
+		pendingErrCode = code
+	}
+	errOutput("  " + err.Error())
 }
 
 func errExit() {
@@ -675,7 +666,24 @@ func errExit() {
 			allErrMsg += errMsgList[k] + "\n"
 		}
 		allErrMsg = strings.ReplaceAll(allErrMsg, "\t", "  ")
-		notify("QCIP | Error", allErrMsg, false)
+		notify("QCIP | Error", redact(allErrMsg), false)
+	}
+	e, ok := takeError()
+	if ok {
+		lines := strings.SplitN(e.Message, "\n", 2)
+		attrs := []any{"code", e.Code}
+		if len(lines) == 2 {
+			attrs = append(attrs, "detail", lines[1])
+		}
+		logger.Log(sinksOnly, slog.LevelError, strings.TrimSuffix(lines[0], ":"), attrs...)
+		report.addError(e)
+	}
+	report.finish()
+	if daemonMode {
+		panic(errRunFailed)
+	}
+	if checkMode {
+		finishCheck(checkUnknown, strings.ReplaceAll(e.Message, ":\n", ": "), nil, nil)
 	}
 	os.Exit(1)
 }
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	outputFormat = "text"                   // --output 指定的输出格式
+	jsonOutput   = os.Stdout                // json 模式下结果写入的位置，其他输出改为写入标准错误
+	report       *runReport                 // 当前运行的结果，不支持 json 输出的命令为 nil
+	daemonMode   = false                    // 是否以 --interval 持续运行
+	errRunFailed = errors.New("run failed") // 持续运行时 errExit 结束本次运行
+
+	pendingErr     []string // errOutput 输出的尚未结束的错误信息
+	pendingErrCode string   // 云服务商返回的错误码
+)
+
+var outputFormats = []string{"text", "json"}
+
+// 一次运行的结果，json 模式下运行结束时输出
+type runReport struct {
+	Command    string         `json:"command"`
+	Version    string         `json:"version"`
+	StartedAt  time.Time      `json:"startedAt"`
+	DurationMs int64          `json:"durationMs"`
+	Success    bool           `json:"success"`
+	IP         string         `json:"ip,omitempty"`
+	IPSource   string         `json:"ipSource,omitempty"` // --ip 或 GetIPAPI 的值
+	Targets    []targetReport `json:"targets"`
+	Errors     []errorReport  `json:"errors"`
+}
+
+type targetReport struct {
+	Target  string       `json:"target"`
+	MType   string       `json:"mtype"`
+	Cached  bool         `json:"cached"` // IP 与上次应用的相同，跳过了检查
+	Rules   []ruleReport `json:"rules"`
+	Missing []string     `json:"missing"` // 没有匹配到任何规则的名称
+}
+
+// run 中为修改前后的地址，plan 和 status 中 changed 表示将会被修改
+type ruleReport struct {
+	Rule     string `json:"rule"`
+	Protocol string `json:"protocol,omitempty"`
+	Port     string `json:"port,omitempty"`
+	Before   string `json:"before"`
+	After    string `json:"after"`
+	Changed  bool   `json:"changed"`
+}
+
+type errorReport struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// 持续运行时逐行输出的事件
+type outputEvent struct {
+	Time       time.Time `json:"time"`
+	Event      string    `json:"event"`
+	Target     string    `json:"target,omitempty"`
+	IP         string    `json:"ip,omitempty"`
+	IPSource   string    `json:"ipSource,omitempty"`
+	Success    *bool     `json:"success,omitempty"`
+	DurationMs int64     `json:"durationMs,omitempty"`
+	Missing    string    `json:"missing,omitempty"`
+	*ruleReport
+	*errorReport
+}
+
+// 没有错误码的错误按照第一行信息分类
+var errorCodes = []struct {
+	prefix string
+	code   string
+}{
+	{"Config error", "ConfigError"},
+	{"Credential error", "CredentialError"},
+	{"IP address check failed", "IPCheckFailed"},
+	{"IP API calling error", "IPLookupFailed"},
+	{"Router calling error", "IPLookupFailed"},
+	{"Error while fetching rules", "FetchRulesFailed"},
+	{"Error while creating client", "FetchRulesFailed"},
+	{"Error while modifying rules", "ModifyRulesFailed"},
+	{"Failed to read state file", "StateError"},
+}
+
+// 开始记录 command 的结果，json 模式下才会记录
+func startReport(command string) {
+	if outputFormat != "json" {
+		return
+	}
+	report = &runReport{
+		Command:   command,
+		Version:   version,
+		StartedAt: time.Now(),
+		Targets:   []targetReport{},
+		Errors:    []errorReport{},
+	}
+	report.emit(outputEvent{Event: "run_started"})
+}
+
+// 持续运行时输出一行事件
+func (r *runReport) emit(e outputEvent) {
+	if !daemonMode {
+		return
+	}
+	e.Time = time.Now()
+	data, _ := json.Marshal(e)
+	jsonOutput.Write(append(data, '\n'))
+}
+
+func (r *runReport) setIP(ip string, source string) {
+	if r == nil {
+		return
+	}
+	r.IP, r.IPSource = ip, source
+	r.emit(outputEvent{Event: "ip_resolved", IP: ip, IPSource: source})
+}
+
+func (r *runReport) addTarget(t targetReport) {
+	if r == nil {
+		return
+	}
+	if t.Rules == nil {
+		t.Rules = []ruleReport{}
+	}
+	if t.Missing == nil {
+		t.Missing = []string{}
+	}
+	r.Targets = append(r.Targets, t)
+	if t.Cached {
+		r.emit(outputEvent{Event: "target_cached", Target: t.Target})
+	}
+	for i := range t.Rules {
+		r.emit(outputEvent{Event: "rule", Target: t.Target, ruleReport: &t.Rules[i]})
+	}
+	for _, name := range t.Missing {
+		r.emit(outputEvent{Event: "rule_missing", Target: t.Target, Missing: name})
+	}
+}
+
+// 按照修改前的地址记录 run 的结果
+func (r *runReport) addRun(configData Config, before map[string]string, ip string) {
+	if r == nil {
+		return
+	}
+	t := targetReport{Target: targetKey(configData), MType: configData.MType}
+	for _, name := range configData.Rules {
+		old, ok := before[name]
+		if !ok {
+			t.Missing = append(t.Missing, name)
+			continue
+		}
+		t.Rules = append(t.Rules, ruleReport{Rule: name, Before: old, After: ip, Changed: old != ip})
+	}
+	r.addTarget(t)
+}
+
+// 记录 plan 和 status 匹配到的规则
+func (r *runReport) addChanges(configData Config, changes []ruleChange, missing []string) {
+	if r == nil {
+		return
+	}
+	t := targetReport{Target: targetKey(configData), MType: configData.MType, Missing: missing}
+	for _, c := range changes {
+		t.Rules = append(t.Rules, ruleReport{
+			Rule:     c.Rule.Description,
+			Protocol: c.Rule.Protocol,
+			Port:     c.Rule.Port,
+			Before:   c.Rule.CIDR,
+			After:    c.NewCIDR,
+			Changed:  !c.inSync(),
+		})
+	}
+	r.addTarget(t)
+}
+
+// 取出 errOutput 输出的错误信息，没有错误码时按照第一行信息分类
+func takeError() (errorReport, bool) {
+	if len(pendingErr) == 0 {
+		return errorReport{}, false
+	}
+	e := errorReport{Code: pendingErrCode, Message: strings.Join(pendingErr, "\n")}
+	if e.Code == "" {
+		e.Code = "Error"
+		for _, c := range errorCodes {
+			if strings.HasPrefix(pendingErr[0], c.prefix) {
+				e.Code = c.code
+				break
+			}
+		}
+	}
+	pendingErr, pendingErrCode = nil, ""
+	return e, true
+}
+
+func (r *runReport) addError(e errorReport) {
+	if r == nil {
+		return
+	}
+	e.Message = redact(e.Message)
+	r.Errors = append(r.Errors, e)
+	r.emit(outputEvent{Event: "error", errorReport: &e})
+}
+
+// 输出结果，json 模式下输出完整的结果，持续运行时输出结束事件
+func (r *runReport) finish() {
+	if r == nil {
+		return
+	}
+	r.DurationMs = time.Since(r.StartedAt).Milliseconds()
+	r.Success = len(r.Errors) == 0
+	if daemonMode {
+		r.emit(outputEvent{Event: "run_finished", Success: &r.Success, DurationMs: r.DurationMs})
+	} else {
+		enc := json.NewEncoder(jsonOutput)
+		enc.SetIndent("", "  ")
+		enc.Encode(r)
+	}
+	report = nil
+}
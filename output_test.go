@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// 将 jsonOutput 替换为临时文件，返回读取其内容的函数
+func captureJSONOutput(t *testing.T) func() string {
+	f, err := os.CreateTemp(t.TempDir(), "output")
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved, savedFormat, savedDaemon := jsonOutput, outputFormat, daemonMode
+	jsonOutput, outputFormat = f, "json"
+	t.Cleanup(func() {
+		jsonOutput, outputFormat, daemonMode = saved, savedFormat, savedDaemon
+		report, pendingErr, pendingErrCode = nil, nil, ""
+		f.Close()
+	})
+	return func() string {
+		data, err := os.ReadFile(f.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+}
+
+// 一次运行：一条匹配的规则被修改，一条规则未找到，并记录一个错误
+func recordTestRun() {
+	startReport("run")
+	report.setIP("203.0.113.7", "IPCONF")
+	configData := Config{MType: "lh", InstanceRegion: "ap-guangzhou", InstanceId: "lhins-abcdefgh", Rules: []string{"ssh", "web"}}
+	report.addRun(configData, map[string]string{"ssh": "198.51.100.1"}, "203.0.113.7")
+	pendingErr = []string{"Error while modifying rules:", "SecretKey=abcdef is invalid"}
+	e, _ := takeError()
+	report.addError(e)
+	report.finish()
+}
+
+func TestReportFinish(t *testing.T) {
+	read := captureJSONOutput(t)
+	daemonMode = false
+	recordTestRun()
+	var got struct {
+		Command    string
+		Version    string
+		StartedAt  string
+		Success    bool
+		IP         string
+		IPSource   string
+		DurationMs *int64
+		Targets    []struct {
+			Target  string
+			MType   string
+			Cached  bool
+			Rules   []ruleReport
+			Missing []string
+		}
+		Errors []errorReport
+	}
+	out := read()
+	dec := json.NewDecoder(strings.NewReader(out))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&got); err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+	if dec.More() {
+		t.Fatalf("more than one json document:\n%s", out)
+	}
+	if got.Command != "run" || got.Version != version || got.Success || got.IP != "203.0.113.7" || got.IPSource != "IPCONF" || got.StartedAt == "" || got.DurationMs == nil {
+		t.Fatalf("unexpected report:\n%s", out)
+	}
+	if len(got.Targets) != 1 || got.Targets[0].Target != "lh/ap-guangzhou/lhins-abcdefgh" || got.Targets[0].MType != "lh" {
+		t.Fatalf("unexpected targets:\n%s", out)
+	}
+	want := ruleReport{Rule: "ssh", Before: "198.51.100.1", After: "203.0.113.7", Changed: true}
+	if tr := got.Targets[0]; len(tr.Rules) != 1 || tr.Rules[0] != want || len(tr.Missing) != 1 || tr.Missing[0] != "web" {
+		t.Fatalf("unexpected rules:\n%s", out)
+	}
+	if len(got.Errors) != 1 || got.Errors[0].Code != "ModifyRulesFailed" || got.Errors[0].Message != "Error while modifying rules:\nSecretKey=*** is invalid" {
+		t.Fatalf("unexpected errors:\n%s", out)
+	}
+	if report != nil {
+		t.Fatal("report is not reset after finish")
+	}
+}
+
+func TestReportEmitLines(t *testing.T) {
+	read := captureJSONOutput(t)
+	daemonMode = true
+	recordTestRun()
+	var events []string
+	scanner := bufio.NewScanner(strings.NewReader(read()))
+	for scanner.Scan() {
+		var e map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("line is not a json object: %s", scanner.Text())
+		}
+		if _, ok := e["time"]; !ok {
+			t.Errorf("event without time: %s", scanner.Text())
+		}
+		event, _ := e["event"].(string)
+		switch event {
+		case "rule":
+			if e["rule"] != "ssh" || e["after"] != "203.0.113.7" || e["target"] != "lh/ap-guangzhou/lhins-abcdefgh" {
+				t.Errorf("unexpected rule event: %s", scanner.Text())
+			}
+		case "error":
+			if e["code"] != "ModifyRulesFailed" {
+				t.Errorf("unexpected error event: %s", scanner.Text())
+			}
+		case "run_finished":
+			if e["success"] != false {
+				t.Errorf("unexpected finish event: %s", scanner.Text())
+			}
+		}
+		events = append(events, event)
+	}
+	want := "run_started ip_resolved rule rule_missing error run_finished"
+	if got := strings.Join(events, " "); got != want {
+		t.Fatalf("events %s, want %s", got, want)
+	}
+}
+
+func TestTakeError(t *testing.T) {
+	defer func() { pendingErr, pendingErrCode = nil, "" }()
+	if _, ok := takeError(); ok {
+		t.Fatal("got an error without pending messages")
+	}
+	for _, c := range errorCodes {
+		pendingErr = []string{c.prefix + ": something went wrong", "detail"}
+		e, ok := takeError()
+		if !ok || e.Code != c.code || e.Message != c.prefix+": something went wrong\ndetail" {
+			t.Errorf("%s: got %+v", c.prefix, e)
+		}
+		if len(pendingErr) != 0 {
+			t.Errorf("%s: pending messages are not cleared", c.prefix)
+		}
+	}
+	pendingErr = []string{"Something unexpected"}
+	if e, _ := takeError(); e.Code != "Error" {
+		t.Errorf("unknown message got code %s, want Error", e.Code)
+	}
+	// 云服务商返回的错误码优先于按照信息分类的错误码
+	pendingErr, pendingErrCode = []string{"Error while modifying rules:"}, "AuthFailure.SecretIdNotFound"
+	if e, _ := takeError(); e.Code != "AuthFailure.SecretIdNotFound" {
+		t.Errorf("got code %s, want the provider code", e.Code)
+	}
+	if pendingErrCode != "" {
+		t.Error("pending error code is not cleared")
+	}
+}
+
+// --output json 时标准输出只有 json，其他信息写入标准错误
+func TestOutputJSONKeepsStdoutClean(t *testing.T) {
+	dir := t.TempDir()
+	config := `{"MType":"lh","SecretId":"AKIDtest","SecretKey":"test","InstanceId":"lhins-abcdefgh","InstanceRegion":"ap-newregion-1","APIProxy":"ftp://proxy","Rules":["ssh"]}`
+	path := filepath.Join(dir, "qcip.json")
+	if err := os.WriteFile(path, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, command := range []string{"run", "plan", "status"} {
+		stdout, stderr, code := runQcip(t, dir, nil, "--output", "json", "--log-level", "debug", command, "-c", path)
+		if code != 1 {
+			t.Errorf("%s: exit code %d, want 1\n%s%s", command, code, stdout, stderr)
+		}
+		var got runReport
+		dec := json.NewDecoder(strings.NewReader(stdout))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&got); err != nil || dec.More() {
+			t.Errorf("%s: stdout is not a single json document: %v\n%s", command, err, stdout)
+			continue
+		}
+		if got.Command != command || len(got.Errors) != 1 || got.Errors[0].Code != "ConfigError" {
+			t.Errorf("%s: unexpected report\n%s", command, stdout)
+		}
+		for _, text := range []string{"ap-newregion-1", "unsupported proxy scheme"} {
+			if !strings.Contains(stderr, text) {
+				t.Errorf("%s: stderr does not contain %q\n%s", command, text, stderr)
+			}
+		}
+	}
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+
+	al_openapi "github.com/alibabacloud-go/darabonba-openapi/v2/client"
+	al_util "github.com/alibabacloud-go/tea-utils/v2/service"
+	"github.com/alibabacloud-go/tea/tea"
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
+	tchttp "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/http"
+)
+
+// qcip doctor 读取策略时调用的只读接口
+var permissionCheckActions = map[string][]string{
+	"tencent": {"cam:ListAttachedUserAllPolicies", "cam:ListAttachedRolePolicies", "cam:GetPolicy"},
+	"alibaba": {"ram:ListPoliciesForUser", "ram:ListGroupsForUser", "ram:ListPoliciesForGroup", "ram:ListPoliciesForRole", "ram:GetPolicy"},
+}
+
+// 调用者及其关联的策略文档
+type callerPolicies struct {
+	Account string   // 主账号 ID，用于生成资源描述
+	Root    bool     // 主账号拥有所有权限
+	Docs    []string // 用户、用户组或角色关联的策略
+}
+
+// CAM 和 RAM 策略，json 字段名不区分大小写，两者可以共用
+type policyDocument struct {
+	Statement []policyStatement
+}
+
+type policyStatement struct {
+	Effect    string
+	Action    stringList
+	Resource  stringList
+	Condition json.RawMessage
+}
+
+// 策略中的 action 和 resource 可以是字符串或数组
+type stringList []string
+
+func (l *stringList) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*l = stringList{s}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*l = list
+	return nil
+}
+
+// 检查策略是否授予 actions 中的接口，返回未授予或被拒绝的接口
+// 带条件的允许视为授予，带条件的拒绝不计入
+func missingActions(docs []string, actions []providerAction, resource string) ([]string, error) {
+	var statements []policyStatement
+	for _, d := range docs {
+		var doc policyDocument
+		if err := json.Unmarshal([]byte(d), &doc); err != nil {
+			return nil, errors.New("cannot parse policy document: " + err.Error())
+		}
+		statements = append(statements, doc.Statement...)
+	}
+	var missing []string
+	for _, a := range actions {
+		res := resource
+		if !a.Resource {
+			res = "*"
+		}
+		allowed, denied := false, false
+		for _, s := range statements {
+			if !statementMatches(s, a.Action, res) {
+				continue
+			}
+			switch strings.ToLower(s.Effect) {
+			case "allow":
+				allowed = true
+			case "deny":
+				if len(s.Condition) == 0 || string(s.Condition) == "null" {
+					denied = true
+				}
+			}
+		}
+		if !allowed || denied {
+			missing = append(missing, a.Action)
+		}
+	}
+	return missing, nil
+}
+
+func statementMatches(s policyStatement, action string, resource string) bool {
+	actionMatched := false
+	for _, a := range s.Action {
+		// 腾讯云旧版策略的 action 带有 name/ 前缀
+		if wildcardMatch(strings.ToLower(strings.TrimPrefix(a, "name/")), strings.ToLower(action)) {
+			actionMatched = true
+			break
+		}
+	}
+	if !actionMatched {
+		return false
+	}
+	for _, r := range s.Resource {
+		if resourceMatches(r, resource) {
+			return true
+		}
+	}
+	return false
+}
+
+// 按照六段式资源描述逐段比较，地域和账号为空时匹配所有
+func resourceMatches(pattern string, resource string) bool {
+	if pattern == "*" {
+		return true
+	}
+	if resource == "*" {
+		// 不支持资源级授权的接口只能由 * 授予
+		return false
+	}
+	p := strings.SplitN(pattern, ":", 6)
+	r := strings.SplitN(resource, ":", 6)
+	if len(p) != len(r) {
+		return wildcardMatch(pattern, resource)
+	}
+	for i := range p {
+		if p[i] == "" && i > 0 && i < len(p)-1 {
+			continue
+		}
+		if !wildcardMatch(p[i], r[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// * 匹配任意字符
+func wildcardMatch(pattern string, s string) bool {
+	if !strings.Contains(pattern, "*") {
+		return pattern == s
+	}
+	re := "^" + strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*") + "$"
+	matched, _ := regexp.MatchString(re, s)
+	return matched
+}
+
+// 从 ARN 中取出扮演的角色，例如 qcs::sts:100001:assumed-role/4611686018427397919/qcip 和 acs:ram::100001:assumed-role/qcip/session
+func assumedRole(arn string) (string, bool) {
+	_, rest, ok := strings.Cut(arn, ":assumed-role/")
+	if !ok {
+		return "", false
+	}
+	role, _, _ := strings.Cut(rest, "/")
+	return role, role != ""
+}
+
+// 读取腾讯云调用者关联的 CAM 策略
+func qcCallerPolicies(cred cloudCredential) (callerPolicies, error) {
+	credential := common.NewTokenCredential(cred.SecretId, cred.SecretKey, cred.Token)
+	var identity struct {
+		Response struct {
+			Arn         string
+			AccountId   string
+			PrincipalId string
+		}
+	}
+	if err := qcCall(credential, "sts", "2018-08-13", "GetCallerIdentity", nil, &identity); err != nil {
+		return callerPolicies{}, err
+	}
+	id := identity.Response
+	result := callerPolicies{Account: id.AccountId, Root: id.PrincipalId != "" && id.PrincipalId == id.AccountId}
+	if result.Root {
+		return result, nil
+	}
+	var policyIds []string
+	role, isRole := assumedRole(id.Arn)
+	for page := 1; ; page++ {
+		var list struct {
+			Response struct {
+				PolicyList []struct{ PolicyId json.Number } // ListAttachedUserAllPolicies
+				List       []struct{ PolicyId json.Number } // ListAttachedRolePolicies
+				TotalNum   int
+			}
+		}
+		var err error
+		if isRole {
+			err = qcCall(credential, "cam", "2019-01-16", "ListAttachedRolePolicies", map[string]interface{}{"RoleId": role, "Page": page, "Rp": 200}, &list)
+		} else {
+			err = qcCall(credential, "cam", "2019-01-16", "ListAttachedUserAllPolicies", map[string]interface{}{"TargetUin": id.PrincipalId, "AttachType": 0, "Page": page, "Rp": 200}, &list)
+		}
+		if err != nil {
+			return result, err
+		}
+		for _, p := range append(list.Response.PolicyList, list.Response.List...) {
+			policyIds = append(policyIds, p.PolicyId.String())
+		}
+		if len(list.Response.PolicyList)+len(list.Response.List) == 0 || len(policyIds) >= list.Response.TotalNum {
+			break
+		}
+	}
+	for _, policyId := range policyIds {
+		n, err := strconv.ParseUint(policyId, 10, 64)
+		if err != nil {
+			return result, errors.New("unexpected policy id " + policyId)
+		}
+		var policy struct {
+			Response struct {
+				PolicyDocument string
+			}
+		}
+		if err = qcCall(credential, "cam", "2019-01-16", "GetPolicy", map[string]interface{}{"PolicyId": n}, &policy); err != nil {
+			return result, err
+		}
+		result.Docs = append(result.Docs, policy.Response.PolicyDocument)
+	}
+	return result, nil
+}
+
+func qcCall(credential *common.Credential, service string, version string, action string, params map[string]interface{}, out interface{}) error {
+	client := common.NewCommonClient(credential, "ap-guangzhou", QCClientProfile(service+".tencentcloudapi.com"))
+	client.WithHttpTransport(apiTransport)
+	request := tchttp.NewCommonRequest(service, version, action)
+	if params != nil {
+		if err := request.SetActionParameters(params); err != nil {
+			return err
+		}
+	}
+	response := tchttp.NewCommonResponse()
+	if err := retrier.do(func() error { return client.Send(request, response) }); err != nil {
+		return err
+	}
+	return json.Unmarshal(response.GetBody(), out)
+}
+
+// 读取阿里云调用者关联的 RAM 策略，包括用户组的策略
+func alCallerPolicies(cred cloudCredential) (callerPolicies, error) {
+	var identity struct {
+		AccountId    string
+		Arn          string
+		IdentityType string
+	}
+	if err := alCall(cred, "sts.aliyuncs.com", "2015-04-01", "GetCallerIdentity", nil, &identity); err != nil {
+		return callerPolicies{}, err
+	}
+	result := callerPolicies{Account: identity.AccountId, Root: identity.IdentityType == "Account"}
+	if result.Root {
+		return result, nil
+	}
+	type policyList struct {
+		Policies struct {
+			Policy []struct {
+				PolicyName string
+				PolicyType string
+			}
+		}
+	}
+	var lists []policyList
+	if role, ok := assumedRole(identity.Arn); ok {
+		var list policyList
+		if err := alCall(cred, "ram.aliyuncs.com", "2015-05-01", "ListPoliciesForRole", map[string]string{"RoleName": role}, &list); err != nil {
+			return result, err
+		}
+		lists = append(lists, list)
+	} else {
+		_, user, ok := strings.Cut(identity.Arn, ":user/")
+		if !ok {
+			return result, errors.New("unexpected caller " + identity.Arn)
+		}
+		var list policyList
+		if err := alCall(cred, "ram.aliyuncs.com", "2015-05-01", "ListPoliciesForUser", map[string]string{"UserName": user}, &list); err != nil {
+			return result, err
+		}
+		lists = append(lists, list)
+		var groups struct {
+			Groups struct {
+				Group []struct{ GroupName string }
+			}
+		}
+		if err := alCall(cred, "ram.aliyuncs.com", "2015-05-01", "ListGroupsForUser", map[string]string{"UserName": user}, &groups); err != nil {
+			return result, err
+		}
+		for _, g := range groups.Groups.Group {
+			var list policyList
+			if err := alCall(cred, "ram.aliyuncs.com", "2015-05-01", "ListPoliciesForGroup", map[string]string{"GroupName": g.GroupName}, &list); err != nil {
+				return result, err
+			}
+			lists = append(lists, list)
+		}
+	}
+	for _, list := range lists {
+		for _, p := range list.Policies.Policy {
+			var policy struct {
+				DefaultPolicyVersion struct {
+					PolicyDocument string
+				}
+			}
+			if err := alCall(cred, "ram.aliyuncs.com", "2015-05-01", "GetPolicy", map[string]string{"PolicyName": p.PolicyName, "PolicyType": p.PolicyType}, &policy); err != nil {
+				return result, err
+			}
+			result.Docs = append(result.Docs, policy.DefaultPolicyVersion.PolicyDocument)
+		}
+	}
+	return result, nil
+}
+
+func alCall(cred cloudCredential, endpoint string, version string, action string, query map[string]string, out interface{}) error {
+	config := &al_openapi.Config{
+		AccessKeyId:     tea.String(cred.SecretId),
+		AccessKeySecret: tea.String(cred.SecretKey),
+		Endpoint:        tea.String(endpoint),
+	}
+	if cred.Token != "" {
+		config.SecurityToken = tea.String(cred.Token)
+	}
+	if err := ALSetProxy(config, apiProxy); err != nil {
+		return err
+	}
+	client, err := al_openapi.NewClient(config)
+	if err != nil {
+		return err
+	}
+	params := &al_openapi.Params{
+		Action:      tea.String(action),
+		Version:     tea.String(version),
+		Protocol:    tea.String("HTTPS"),
+		Pathname:    tea.String("/"),
+		Method:      tea.String("POST"),
+		AuthType:    tea.String("AK"),
+		Style:       tea.String("RPC"),
+		ReqBodyType: tea.String("formData"),
+		BodyType:    tea.String("json"),
+	}
+	request := &al_openapi.OpenApiRequest{Query: map[string]*string{}}
+	for k, v := range query {
+		request.Query[k] = tea.String(v)
+	}
+	var result map[string]interface{}
+	err = retrier.do(func() (err error) {
+		result, err = client.CallApi(params, request, &al_util.RuntimeOptions{})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	raw, _ := json.Marshal(result["body"])
+	return json.Unmarshal(raw, out)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// qcip plan，显示将会修改的规则，不修改任何规则
+func planCommand() {
+	startReport("plan")
+	configData := prepareRun()
+	ip := resolveIP(configData)
+	rules, err := fetchFirewallRules(configData, getCredential(configData))
+	if err != nil {
+		errOutput("Error while fetching rules:")
+		errDetail(err)
+		errExit()
+	}
+	changes, missing := matchRules(rules, configData.Rules, ip)
+	report.addChanges(configData, changes, missing)
+	fmt.Printf("Target: %s\nIP: %s\n", targetKey(configData), ip)
+	printPlan(changes, missing)
+	report.finish()
+}
+
+func printPlan(changes []ruleChange, missing []string) {
+	toChange := 0
+	for _, c := range changes {
+		r := c.Rule
+		if c.inSync() {
+			fmt.Printf("  = %s  %s %s  %s\n", r.Description, r.Protocol, r.Port, r.CIDR)
+		} else {
+			fmt.Printf("\033[33m  ~ %s  %s %s  %s -> %s\033[0m\n", r.Description, r.Protocol, r.Port, r.CIDR, c.NewCIDR)
+			toChange++
+		}
+	}
+	for _, name := range missing {
+		fmt.Printf("\033[31m  ! %s  no matching rule\033[0m\n", name)
+	}
+	fmt.Printf("Plan: %d rule(s) to change, %d in sync, %d not found\n", toChange, len(changes)-toChange, len(missing))
+}
+
+// qcip rollback，将规则恢复为最后一次修改前的地址
+func rollbackCommand() {
+	configData := prepareRun()
+	statePath := configData.StateFile
+	if statePath == "" {
+		statePath = defaultStatePath()
+	}
+	state, err := loadState(statePath)
+	if err != nil {
+		errOutput("Failed to read state file " + statePath + ": " + err.Error())
+		errExit()
+	}
+	key := targetKey(configData)
+	groups := make(map[string][]string)
+	if target, ok := state.Targets[key]; ok {
+		for _, rule := range configData.Rules {
+			if r, ok := target.Rules[rule]; ok && r.PreviousIP != "" {
+				groups[r.PreviousIP] = append(groups[r.PreviousIP], rule)
+			}
+		}
+	}
+	if len(groups) == 0 {
+		errOutput("Nothing to roll back: no previous ip recorded for " + key)
+		errExit()
+	}
+	ips := make([]string, 0, len(groups))
+	for ip := range groups {
+		ips = append(ips, ip)
+	}
+	sort.Strings(ips)
+	for _, ip := range ips {
+		// 按原地址分组，复用修改规则的流程
+		rollbackConfig := configData
+		rollbackConfig.Rules = groups[ip]
+		fmt.Printf("Rolling back %v to %s\n", groups[ip], ip)
+		before := applyTarget(rollbackConfig, ip)
+		state.record(key, rollbackConfig.Rules, ip, before)
+	}
+	if err = saveState(statePath, state); err != nil {
+		logger.Warn("Failed to save state file", "path", statePath, "error", err)
+	}
+}
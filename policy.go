@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// 各机器类型调用的云 API，policy 命令据此生成最小权限策略
+// Resource 为 false 的接口不支持资源级授权，只能授权到 *
+type providerAction struct {
+	Action   string
+	Resource bool
+}
+
+var providerActions = map[string][]providerAction{
+	"lh": {
+		{"lighthouse:DescribeFirewallRules", true},
+		{"lighthouse:ModifyFirewallRules", true},
+	},
+	"cvm": {
+		{"vpc:DescribeSecurityGroupPolicies", true},
+		{"vpc:ModifySecurityGroupPolicies", true},
+	},
+	"allh": {
+		{"swas-open:ListFirewallRules", true},
+		{"swas-open:ModifyFirewallRule", true},
+		{"swas-open:CreateFirewallRules", true}, // qcip rules apply
+		{"swas-open:DeleteFirewallRule", true},
+	},
+}
+
+// qcip init 列出地域、实例和安全组时额外调用的接口
+var providerInitActions = map[string][]providerAction{
+	"lh": {
+		{"lighthouse:DescribeRegions", false},
+		{"lighthouse:DescribeInstances", false},
+	},
+	"cvm": {
+		{"cvm:DescribeRegions", false},
+		{"vpc:DescribeSecurityGroups", false},
+	},
+	"allh": {
+		{"swas-open:ListRegions", false},
+		{"swas-open:ListInstances", false},
+	},
+}
+
+// 腾讯云 CAM 策略
+type camPolicy struct {
+	Version   string         `json:"version"`
+	Statement []camStatement `json:"statement"`
+}
+
+type camStatement struct {
+	Effect   string   `json:"effect"`
+	Action   []string `json:"action"`
+	Resource []string `json:"resource"`
+}
+
+// 阿里云 RAM 策略
+type ramPolicy struct {
+	Version   string         `json:"Version"`
+	Statement []ramStatement `json:"Statement"`
+}
+
+type ramStatement struct {
+	Effect   string   `json:"Effect"`
+	Action   []string `json:"Action"`
+	Resource []string `json:"Resource"`
+}
+
+// 配置中实例或安全组的资源描述
+func policyResource(configData Config, uin string) string {
+	switch configData.MType {
+	case "lh":
+		return "qcs::lighthouse:" + configData.InstanceRegion + ":" + qcAccount(uin) + ":instance/" + configData.InstanceId
+	case "cvm":
+		// 安全组在 CAM 中属于 cvm 业务
+		return "qcs::cvm:" + configData.SecurityGroupRegion + ":" + qcAccount(uin) + ":sg/" + configData.SecurityGroupId
+	case "allh":
+		account := uin
+		if account == "" {
+			account = "*"
+		}
+		return "acs:swas-open:" + configData.InstanceRegion + ":" + account + ":instance/" + configData.InstanceId
+	}
+	return ""
+}
+
+func qcAccount(uin string) string {
+	if uin == "" {
+		return ""
+	}
+	return "uin/" + uin
+}
+
+// 按照 providerActions 生成策略，withInit 时附加 qcip init 所需的只读接口
+// 配置了 RoleArn 时附加扮演该角色的权限
+func buildPolicy(configData Config, uin string, withInit bool) interface{} {
+	actions := providerActions[configData.MType]
+	if withInit {
+		actions = append(append([]providerAction{}, actions...), providerInitActions[configData.MType]...)
+	}
+	var scoped, global []string
+	for _, a := range actions {
+		if a.Resource {
+			scoped = append(scoped, a.Action)
+		} else {
+			global = append(global, a.Action)
+		}
+	}
+	resource := policyResource(configData, uin)
+	if configData.MType == "allh" {
+		policy := ramPolicy{Version: "1"}
+		policy.Statement = append(policy.Statement, ramStatement{"Allow", scoped, []string{resource}})
+		if len(global) > 0 {
+			policy.Statement = append(policy.Statement, ramStatement{"Allow", global, []string{"*"}})
+		}
+		if configData.RoleArn != "" {
+			policy.Statement = append(policy.Statement, ramStatement{"Allow", []string{"sts:AssumeRole"}, []string{configData.RoleArn}})
+		}
+		return policy
+	}
+	policy := camPolicy{Version: "2.0"}
+	policy.Statement = append(policy.Statement, camStatement{"allow", scoped, []string{resource}})
+	if len(global) > 0 {
+		policy.Statement = append(policy.Statement, camStatement{"allow", global, []string{"*"}})
+	}
+	if configData.RoleArn != "" {
+		policy.Statement = append(policy.Statement, camStatement{"allow", []string{"sts:AssumeRole"}, []string{configData.RoleArn}})
+	}
+	return policy
+}
+
+// qcip policy，输出配置中实例或安全组的最小权限策略
+func policyCommand(uin string, withInit bool) {
+	confPath = findConfig()
+	configData, errs, err := loadConfig(confPath)
+	if err != nil {
+		errOutput("Config error: " + err.Error())
+		errExit()
+	}
+	// 生成策略不需要密钥，忽略密钥相关的错误
+	var fatal []configError
+	for _, e := range errs {
+		if !e.Warning && e.Path != "SecretId" && e.Path != "SecretKey" && e.Path != "SessionToken" {
+			fatal = append(fatal, e)
+		}
+	}
+	if len(fatal) > 0 {
+		errOutput("Config error:")
+		for _, e := range fatal {
+			errOutput("  " + e.format(confPath))
+		}
+		errExit()
+	}
+	if policyResource(configData, uin) == "" {
+		errOutput("Config error: machine type is empty")
+		errExit()
+	}
+	data, _ := json.MarshalIndent(buildPolicy(configData, uin, withInit), "", "    ")
+	fmt.Fprintln(os.Stdout, string(data))
+}
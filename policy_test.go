@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestBuildPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+		want   []string
+	}{
+		{"lh", Config{MType: "lh", InstanceRegion: "ap-guangzhou", InstanceId: "lhins-abcdefgh"},
+			[]string{`"qcs::lighthouse:ap-guangzhou:uin/100001:instance/lhins-abcdefgh"`, `"lighthouse:ModifyFirewallRules"`}},
+		{"cvm", Config{MType: "cvm", SecurityGroupRegion: "ap-shanghai", SecurityGroupId: "sg-abcdefgh"},
+			[]string{`"qcs::cvm:ap-shanghai:uin/100001:sg/sg-abcdefgh"`, `"vpc:ModifySecurityGroupPolicies"`}},
+		{"cvm role", Config{MType: "cvm", SecurityGroupRegion: "ap-shanghai", SecurityGroupId: "sg-abcdefgh", RoleArn: "qcs::cam::uin/100001:roleName/qcip"},
+			[]string{`"sts:AssumeRole"`, `"qcs::cam::uin/100001:roleName/qcip"`}},
+		{"allh role", Config{MType: "allh", InstanceRegion: "cn-hangzhou", InstanceId: "0123456789abcdef0123456789abcdef", RoleArn: "acs:ram::100001:role/qcip"},
+			[]string{`"acs:swas-open:cn-hangzhou:100001:instance/0123456789abcdef0123456789abcdef"`, `"swas-open:CreateFirewallRules"`, `"swas-open:DeleteFirewallRule"`, `"sts:AssumeRole"`, `"acs:ram::100001:role/qcip"`}},
+	}
+	for _, tt := range tests {
+		data, err := json.Marshal(buildPolicy(tt.config, "100001", false))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, w := range tt.want {
+			if !strings.Contains(string(data), w) {
+				t.Errorf("%s: policy %s does not contain %s", tt.name, data, w)
+			}
+		}
+		if tt.config.RoleArn == "" && strings.Contains(string(data), "sts:AssumeRole") {
+			t.Errorf("%s: policy %s contains sts:AssumeRole without RoleArn", tt.name, data)
+		}
+	}
+}
+
+func TestMissingActions(t *testing.T) {
+	lhResource := "qcs::lighthouse:ap-guangzhou:uin/100001:instance/lhins-abcdefgh"
+	alResource := "acs:swas-open:cn-hangzhou:100001:instance/0123456789abcdef0123456789abcdef"
+	tests := []struct {
+		name     string
+		mType    string
+		resource string
+		docs     []string
+		want     string
+	}{
+		{"exact", "lh", lhResource,
+			[]string{`{"version":"2.0","statement":[{"effect":"allow","action":["lighthouse:DescribeFirewallRules","lighthouse:ModifyFirewallRules"],"resource":["qcs::lighthouse:ap-guangzhou:uin/100001:instance/lhins-abcdefgh"]}]}`}, ""},
+		{"service wildcard", "lh", lhResource,
+			[]string{`{"version":"2.0","statement":[{"effect":"allow","action":"lighthouse:*","resource":"*"}]}`}, ""},
+		{"old action prefix and empty region", "lh", lhResource,
+			[]string{`{"version":"2.0","statement":[{"effect":"allow","action":["name/lighthouse:*FirewallRules"],"resource":["qcs::lighthouse::uin/100001:instance/*"]}]}`}, ""},
+		{"split across policies", "lh", lhResource,
+			[]string{`{"statement":[{"effect":"allow","action":"lighthouse:DescribeFirewallRules","resource":"*"}]}`,
+				`{"statement":[{"effect":"allow","action":"lighthouse:ModifyFirewallRules","resource":"*"}]}`}, ""},
+		{"read only", "lh", lhResource,
+			[]string{`{"statement":[{"effect":"allow","action":"lighthouse:Describe*","resource":"*"}]}`}, "lighthouse:ModifyFirewallRules"},
+		{"other instance", "lh", lhResource,
+			[]string{`{"statement":[{"effect":"allow","action":"lighthouse:*","resource":"qcs::lighthouse:ap-guangzhou:uin/100001:instance/lhins-other"}]}`}, "lighthouse:DescribeFirewallRules, lighthouse:ModifyFirewallRules"},
+		{"other account", "lh", lhResource,
+			[]string{`{"statement":[{"effect":"allow","action":"lighthouse:*","resource":"qcs::lighthouse::uin/100002:instance/*"}]}`}, "lighthouse:DescribeFirewallRules, lighthouse:ModifyFirewallRules"},
+		{"explicit deny", "lh", lhResource,
+			[]string{`{"statement":[{"effect":"allow","action":"*","resource":"*"},{"effect":"deny","action":"lighthouse:Modify*","resource":"*"}]}`}, "lighthouse:ModifyFirewallRules"},
+		{"conditional deny", "lh", lhResource,
+			[]string{`{"statement":[{"effect":"allow","action":"*","resource":"*"},{"effect":"deny","action":"*","resource":"*","condition":{"ip_not_equal":{"qcs:ip":"10.0.0.0/8"}}}]}`}, ""},
+		{"no policies", "cvm", "qcs::cvm:ap-shanghai:uin/100001:sg/sg-abcdefgh", nil, "vpc:DescribeSecurityGroupPolicies, vpc:ModifySecurityGroupPolicies"},
+		{"ram system policy", "allh", alResource,
+			[]string{`{"Version":"1","Statement":[{"Effect":"Allow","Action":"swas-open:*","Resource":"*"}]}`}, ""},
+		{"ram modify only", "allh", alResource,
+			[]string{`{"Version":"1","Statement":[{"Effect":"Allow","Action":["swas-open:ListFirewallRules","swas-open:ModifyFirewallRule"],"Resource":"acs:swas-open:*:100001:instance/*"}]}`}, "swas-open:CreateFirewallRules, swas-open:DeleteFirewallRule"},
+	}
+	for _, tt := range tests {
+		missing, err := missingActions(tt.docs, providerActions[tt.mType], tt.resource)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got := strings.Join(missing, ", "); got != tt.want {
+			t.Errorf("%s: missing %q, want %q", tt.name, got, tt.want)
+		}
+	}
+	if _, err := missingActions([]string{"not json"}, providerActions["lh"], lhResource); err == nil {
+		t.Error("malformed policy document was accepted")
+	}
+}
+
+func TestAssumedRole(t *testing.T) {
+	tests := []struct {
+		arn, role string
+	}{
+		{"qcs::sts:100001:assumed-role/4611686018427397919/qcip", "4611686018427397919"},
+		{"acs:ram::100001:assumed-role/qcip/session", "qcip"},
+		{"acs:ram::100001:user/alice", ""},
+		{"qcs::cam::uin/100001:uin/100002", ""},
+	}
+	for _, tt := range tests {
+		if role, ok := assumedRole(tt.arn); role != tt.role || ok != (tt.role != "") {
+			t.Errorf("assumedRole(%s) = %q, %v", tt.arn, role, ok)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"sort"
+	"strings"
+)
+
+const profileEnv = "QCIP_PROFILE" // 未使用 --profile 时选择的配置档
+
+var profileName = os.Getenv(profileEnv) // 选择的配置档名称
+
+// 将 Profiles 中选定的配置档覆盖到顶层字段，并移除 Profiles
+// 配置档之间共享顶层的密钥和目标，只需填写不同的字段，例如 Rules GetIPAPI IPPrefix
+func applyProfile(root *configNode, name string) []configError {
+	m, ok := root.Value.(map[string]*configNode)
+	if !ok {
+		return nil
+	}
+	profilesNode, ok := m["Profiles"]
+	delete(m, "Profiles")
+	if !ok {
+		if name != "" {
+			return []configError{{Msg: "profile " + name + " not found, the config file has no Profiles"}}
+		}
+		return nil
+	}
+	profiles, _ := profilesNode.Value.(map[string]*configNode)
+	var errs []configError
+	for pname, p := range profiles {
+		if p.has("Profiles") {
+			errs = append(errs, configError{Line: p.lineOf("Profiles"), Path: "Profiles." + pname + ".Profiles", Msg: "profiles cannot be nested"})
+		}
+	}
+	if len(errs) > 0 || name == "" {
+		return errs
+	}
+	selected, ok := profiles[name]
+	if !ok {
+		names := make([]string, 0, len(profiles))
+		for pname := range profiles {
+			names = append(names, pname)
+		}
+		sort.Strings(names)
+		return []configError{{Line: profilesNode.Line, Path: "Profiles", Msg: "profile " + name + " not found, available: " + strings.Join(names, ", ")}}
+	}
+	if fields, ok := selected.Value.(map[string]*configNode); ok {
+		for k, v := range fields {
+			m[k] = v
+		}
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	al_openapi "github.com/alibabacloud-go/darabonba-openapi/v2/client"
+	"github.com/alibabacloud-go/tea/tea"
+)
+
+// 代理配置的取值
+// direct 不使用代理；env 使用环境变量 HTTP_PROXY HTTPS_PROXY NO_PROXY；其他取值为代理地址，支持 http https socks5
+const (
+	proxyDirect = "direct"
+	proxyEnv    = "env"
+)
+
+var (
+	apiProxy     = proxyEnv // 云服务商 API 使用的代理，默认跟随环境变量
+	apiTransport *http.Transport
+)
+
+// 解析代理地址
+func parseProxyURL(setting string) (*url.URL, error) {
+	u, err := url.Parse(setting)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	case "socks5h":
+		// Go 的 socks5 代理本身就由代理服务器解析域名
+		u.Scheme = "socks5"
+	default:
+		return nil, errors.New("unsupported proxy scheme \"" + u.Scheme + "\", it should be http, https or socks5")
+	}
+	if u.Host == "" {
+		return nil, errors.New("proxy address is missing the host")
+	}
+	return u, nil
+}
+
+// 校验代理配置
+func checkProxySetting(setting string) error {
+	if setting == "" || setting == proxyDirect || setting == proxyEnv {
+		return nil
+	}
+	_, err := parseProxyURL(setting)
+	return err
+}
+
+func proxyFunc(setting string) (func(*http.Request) (*url.URL, error), error) {
+	switch setting {
+	case "", proxyDirect:
+		return nil, nil
+	case proxyEnv:
+		return http.ProxyFromEnvironment, nil
+	}
+	u, err := parseProxyURL(setting)
+	if err != nil {
+		return nil, err
+	}
+	return http.ProxyURL(u), nil
+}
+
+// 查询IP使用的 http 客户端，默认直连，以免获取到代理服务器的IP
+func newIPHTTPClient(setting string) (*http.Client, error) {
+	proxy, err := proxyFunc(setting)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{
+		Timeout: time.Second * 10,
+		Transport: &http.Transport{
+			Proxy: proxy,
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "tcp4", addr)
+			},
+		},
+	}, nil
+}
+
+// 腾讯云 API 使用的 Transport
+func newAPITransport(setting string) (*http.Transport, error) {
+	if setting == "" {
+		setting = proxyEnv
+	}
+	proxy, err := proxyFunc(setting)
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxy
+	return transport, nil
+}
+
+// 为阿里云客户端设置代理
+// tea 的 Socks5Proxy 会把完整的代理链接当作地址拨号，因此 socks5 代理同样通过 HttpsProxy 交给 http.Transport 处理
+func ALSetProxy(config *al_openapi.Config, setting string) error {
+	switch setting {
+	case "", proxyEnv:
+		// tea 默认读取环境变量中的代理
+		return nil
+	case proxyDirect:
+		config.NoProxy = config.Endpoint
+		return nil
+	}
+	u, err := parseProxyURL(setting)
+	if err != nil {
+		return err
+	}
+	if u.User != nil {
+		// tea 会把代理的认证信息作为 Proxy-Authorization 请求头附加在每个请求上，经过隧道后会直接发给阿里云
+		return errors.New("proxy with credentials is not supported for Alibaba Cloud")
+	}
+	config.HttpProxy = tea.String(u.String())
+	config.HttpsProxy = tea.String(u.String())
+	return nil
+}
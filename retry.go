@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alibabacloud-go/tea/tea"
+	qc_errors "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/errors"
+)
+
+const (
+	defaultRetryBaseDelay  = 500 * time.Millisecond // 首次重试前的等待时间
+	defaultRetryMaxDelay   = 10 * time.Second       // 单次重试的最长等待时间
+	defaultRetryMaxElapsed = 60 * time.Second       // 重试的最长总耗时
+)
+
+// 重试策略，IP查询与云服务商API调用共用
+type retryPolicy struct {
+	MaxRetries int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+	MaxElapsed time.Duration
+}
+
+// 默认的重试策略，运行时根据配置文件调整
+var retrier = newRetryPolicy(3, defaultRetryMaxElapsed)
+
+func newRetryPolicy(maxRetries int, maxElapsed time.Duration) retryPolicy {
+	return retryPolicy{
+		MaxRetries: maxRetries,
+		BaseDelay:  defaultRetryBaseDelay,
+		MaxDelay:   defaultRetryMaxDelay,
+		MaxElapsed: maxElapsed,
+	}
+}
+
+// HTTP 响应状态码错误
+type httpStatusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *httpStatusError) Error() string {
+	return "unexpected http status " + e.Status
+}
+
+// 执行 fn，遇到临时性错误时以带抖动的指数退避重试，其他错误立即返回
+func (p retryPolicy) do(fn func() error) error {
+	start := time.Now()
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = fn()
+		if err == nil || !isRetryable(err) || attempt >= p.MaxRetries {
+			return err
+		}
+		delay := p.backoff(attempt)
+		if p.MaxElapsed > 0 && time.Since(start)+delay > p.MaxElapsed {
+			return err
+		}
+		logger.Warn("Retrying", "reason", retryReason(err), "attempt", attempt+1, "max_retries", p.MaxRetries, "delay", delay.Round(time.Millisecond).String())
+		time.Sleep(delay)
+	}
+}
+
+// 计算第 attempt 次重试前的等待时间，在指数退避的基础上取 [delay/2, delay) 的随机值
+func (p retryPolicy) backoff(attempt int) time.Duration {
+	delay := p.BaseDelay
+	for i := 0; i < attempt && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	half := delay / 2
+	if half <= 0 {
+		return delay
+	}
+	return half + time.Duration(rand.Int63n(int64(half)))
+}
+
+// 判断错误是否为可重试的临时性错误
+// 超时、网络错误、5xx、429 和限流错误码可以重试，鉴权和参数错误、域名不存在和证书校验失败立即失败
+func isRetryable(err error) bool {
+	if permanentNetError(err) {
+		return false
+	}
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) {
+		return retryableStatus(statusErr.StatusCode)
+	}
+	var qcErr *qc_errors.TencentCloudSDKError
+	if errors.As(err, &qcErr) {
+		// 腾讯云 SDK 将网络错误转换为文本，只能根据信息判断
+		if qcErr.Code == "ClientError.NetworkError" && permanentNetMessage(qcErr.Message) {
+			return false
+		}
+		return retryableQCCode(qcErr.Code)
+	}
+	var alErr *tea.SDKError
+	if errors.As(err, &alErr) {
+		if alErr.StatusCode != nil && retryableStatus(*alErr.StatusCode) {
+			return true
+		}
+		return alErr.Code != nil && retryableALCode(*alErr.Code)
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr)
+}
+
+// 重试无法恢复的网络错误：域名不存在、证书校验失败
+func permanentNetError(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return true
+	}
+	var (
+		certErr      *tls.CertificateVerificationError
+		authorityErr x509.UnknownAuthorityError
+		invalidErr   x509.CertificateInvalidError
+		hostErr      x509.HostnameError
+	)
+	return errors.As(err, &certErr) || errors.As(err, &authorityErr) ||
+		errors.As(err, &invalidErr) || errors.As(err, &hostErr)
+}
+
+func permanentNetMessage(msg string) bool {
+	return strings.Contains(msg, "no such host") || strings.Contains(msg, "x509: ") ||
+		strings.Contains(msg, "tls: failed to verify certificate")
+}
+
+func retryableStatus(code int) bool {
+	return code == http.StatusRequestTimeout || code == http.StatusTooManyRequests || code >= 500
+}
+
+// 腾讯云的临时性错误码
+func retryableQCCode(code string) bool {
+	for _, prefix := range []string{"RequestLimitExceeded", "InternalError", "ClientError.NetworkError", "ClientError.CircuitBreakerError"} {
+		if code == prefix || strings.HasPrefix(code, prefix+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// 阿里云的临时性错误码
+func retryableALCode(code string) bool {
+	if strings.HasPrefix(code, "Throttling") || strings.HasPrefix(code, "ServiceUnavailable") {
+		return true
+	}
+	return code == "InternalError" || code == "UnknownError"
+}
+
+// 重试时显示的简短原因
+func retryReason(err error) string {
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) {
+		return "http status " + strconv.Itoa(statusErr.StatusCode)
+	}
+	var qcErr *qc_errors.TencentCloudSDKError
+	if errors.As(err, &qcErr) {
+		return qcErr.Code
+	}
+	var alErr *tea.SDKError
+	if errors.As(err, &alErr) && alErr.Code != nil {
+		return *alErr.Code
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return "timeout"
+	}
+	return "network error"
+}
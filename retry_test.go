@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"testing"
+
+	qc_errors "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/errors"
+)
+
+func TestIsRetryable(t *testing.T) {
+	wrap := func(err error) error {
+		return &url.Error{Op: "Get", URL: "https://api.ipify.org", Err: err}
+	}
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"timeout", wrap(context.DeadlineExceeded), true},
+		{"connection refused", wrap(&net.OpError{Op: "dial", Err: errors.New("connection refused")}), true},
+		{"dns temporary", wrap(&net.DNSError{Err: "server misbehaving", Name: "api.ipify.org", IsTemporary: true}), true},
+		{"dns not found", wrap(&net.DNSError{Err: "no such host", Name: "api.ipfy.org", IsNotFound: true}), false},
+		{"unknown authority", wrap(x509.UnknownAuthorityError{}), false},
+		{"hostname mismatch", wrap(x509.HostnameError{Host: "example.com", Certificate: &x509.Certificate{}}), false},
+		{"certificate verification", wrap(&tls.CertificateVerificationError{Err: x509.CertificateInvalidError{Reason: x509.Expired}}), false},
+		{"http 503", &httpStatusError{StatusCode: 503, Status: "503 Service Unavailable"}, true},
+		{"http 403", &httpStatusError{StatusCode: 403, Status: "403 Forbidden"}, false},
+		{"qc limit", qc_errors.NewTencentCloudSDKError("RequestLimitExceeded", "too many requests", ""), true},
+		{"qc network", qc_errors.NewTencentCloudSDKError("ClientError.NetworkError", "Fail to get response because dial tcp: i/o timeout", ""), true},
+		{"qc no such host", qc_errors.NewTencentCloudSDKError("ClientError.NetworkError", "Fail to get response because dial tcp: lookup lighthouse.tencentcloudapi.com: no such host", ""), false},
+		{"qc x509", qc_errors.NewTencentCloudSDKError("ClientError.NetworkError", "Fail to get response because x509: certificate signed by unknown authority", ""), false},
+		{"qc auth", qc_errors.NewTencentCloudSDKError("AuthFailure.SignatureFailure", "bad signature", ""), false},
+		{"plain", errors.New("invalid config"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRetryable(fmt.Errorf("request failed: %w", tt.err)); got != tt.want {
+				t.Fatalf("isRetryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+var ruleExportFormats = []string{"json", "csv", "yaml"}
+
+// 导出的规则清单，与云服务商无关
+type ruleInventory struct {
+	Target     string         `json:"target" yaml:"target"`
+	ExportedAt time.Time      `json:"exportedAt" yaml:"exportedAt"`
+	Rules      []firewallRule `json:"rules" yaml:"rules"`
+}
+
+// 获取目标上的全部规则，出错时退出
+func getFirewallRules(configData Config) []firewallRule {
+	rules, err := fetchFirewallRules(configData, getCredential(configData))
+	if err != nil {
+		errOutput("Error while fetching rules:")
+		errDetail(err)
+		errExit()
+	}
+	return rules
+}
+
+// qcip rules list，以表格显示目标上的全部规则
+func rulesListCommand() {
+	configData := prepareRun()
+	rules := getFirewallRules(configData)
+	fmt.Printf("Target: %s\n", targetKey(configData))
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "  DIRECTION\tPROTOCOL\tPORT\tSOURCE\tACTION\tDESCRIPTION")
+	for _, r := range rules {
+		protocol, port := r.Protocol, r.Port
+		if r.ServiceTemplate != "" {
+			protocol, port = r.ServiceTemplate, "-"
+		}
+		fmt.Fprintf(w, "  %s\t%s\t%s\t%s\t%s\t%s\n", r.Direction, protocol, port, r.source(), r.Action, r.Description)
+	}
+	w.Flush()
+	fmt.Printf("%d rule(s)\n", len(rules))
+}
+
+// qcip rules export，将目标上的全部规则写入 output，为空时写入标准输出
+func rulesExportCommand(format string, output string) {
+	configData := prepareRun()
+	inventory := ruleInventory{
+		Target:     targetKey(configData),
+		ExportedAt: time.Now().UTC().Truncate(time.Second),
+		Rules:      getFirewallRules(configData),
+	}
+	if inventory.Rules == nil {
+		inventory.Rules = []firewallRule{}
+	}
+	var w io.Writer = os.Stdout
+	if output != "" {
+		f, err := os.Create(output)
+		if err != nil {
+			errOutput("Failed to create " + output + ": " + err.Error())
+			errExit()
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := writeInventory(w, inventory, format); err != nil {
+		errOutput("Failed to export rules: " + err.Error())
+		errExit()
+	}
+	if output != "" {
+		fmt.Printf("Exported %d rule(s) of %s to %s\n", len(inventory.Rules), inventory.Target, output)
+	}
+	if err := checkExpressible(inventory.Rules, configData.MType); err != nil {
+		fmt.Fprintf(os.Stderr, "\033[33mWarning: the exported file cannot be applied as is:\n%s\033[0m\n", err.Error())
+	}
+}
+
+func writeInventory(w io.Writer, inventory ruleInventory, format string) error {
+	switch format {
+	case "json":
+		data, err := json.MarshalIndent(inventory, "", "    ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(data))
+		return err
+	case "yaml":
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		if err := enc.Encode(inventory); err != nil {
+			return err
+		}
+		return enc.Close()
+	case "csv":
+		// csv 每行一条规则，目标写入每一行以便合并多个文件
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"target", "direction", "protocol", "port", "cidr", "action", "description", "securityGroupId", "addressTemplate", "serviceTemplate"})
+		for _, r := range inventory.Rules {
+			cw.Write([]string{inventory.Target, r.Direction, r.Protocol, r.Port, r.CIDR, r.Action, r.Description, r.SecurityGroupId, r.AddressTemplate, r.ServiceTemplate})
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+	return fmt.Errorf("unknown format %s", format)
+}
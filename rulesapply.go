@@ -0,0 +1,484 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+
+	al_swas_open "github.com/alibabacloud-go/swas-open-20200601/client"
+	al_util "github.com/alibabacloud-go/tea-utils/v2/service"
+	"github.com/alibabacloud-go/tea/tea"
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
+	qc_lighthouse "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/lighthouse/v20200324"
+	qc_vpc "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/vpc/v20170312"
+	"gopkg.in/yaml.v3"
+)
+
+const selfPlaceholder = "@self" // 规则文件中代表当前公网IP的地址
+
+// 规则文件与目标上的规则之间的差异
+type ruleDiff struct {
+	Keep         []firewallRule
+	Add          []firewallRule
+	Delete       []firewallRule
+	OrderChanged bool   // 腾讯云的规则按顺序生效，集合相同但顺序不同时也需要修改
+	Version      string // 生成计划时腾讯云安全组规则的版本
+}
+
+func (d ruleDiff) empty() bool {
+	return len(d.Add) == 0 && len(d.Delete) == 0 && !d.OrderChanged
+}
+
+// qcip rules apply，使目标上的规则与规则文件完全一致
+func rulesApplyCommand(file string, yes bool, dryRun bool) {
+	configData := prepareRun()
+	desired, err := readRulesFile(file, configData)
+	if err != nil {
+		errOutput("Rules file error: " + err.Error())
+		errExit()
+	}
+	for _, r := range desired {
+		if r.CIDR == selfPlaceholder {
+			ip := resolveIP(configData)
+			for i := range desired {
+				if desired[i].CIDR == selfPlaceholder {
+					desired[i].CIDR = ip
+				}
+			}
+			fmt.Printf("IP: %s\n", ip)
+			break
+		}
+	}
+	cred := getCredential(configData)
+	current, version, err := fetchRuleSet(configData, cred)
+	if err != nil {
+		errOutput("Error while fetching rules:")
+		errDetail(err)
+		errExit()
+	}
+	// 修改时会删除规则文件中没有的规则，目标上有无法表示的规则时拒绝修改
+	if err = checkExpressible(current, configData.MType); err != nil {
+		errOutput("Refusing to apply " + file + ":")
+		errDetail(err)
+		errExit()
+	}
+	diff := diffRules(current, desired, configData.MType != "allh")
+	diff.Version = version
+	fmt.Printf("Target: %s\n", targetKey(configData))
+	printRuleDiff(diff)
+	if diff.empty() || dryRun {
+		return
+	}
+	if !yes && !askYesNo("Apply these changes?", false) {
+		fmt.Println("Cancelled, nothing was changed")
+		return
+	}
+	if err = replaceRules(configData, cred, desired, diff); err != nil {
+		errOutput("Error while applying rules:")
+		errDetail(err)
+		errExit()
+	}
+	// 规则可能已不是缓存中记录的地址，清除该目标的缓存
+	statePath := configData.StateFile
+	if statePath == "" {
+		statePath = defaultStatePath()
+	}
+	if state, err := loadState(statePath); err == nil {
+		delete(state.Targets, targetKey(configData))
+		if err = saveState(statePath, state); err != nil {
+			logger.Warn("Failed to save state file", "path", statePath, "error", err)
+		}
+	}
+	key := targetKey(configData)
+	for _, r := range diff.Add {
+		logger.InfoContext(sinksOnly, "Rule added", "target", key, "rule", r.Description, "direction", r.Direction, "protocol", r.Protocol, "port", r.Port, "source", r.source(), "action", r.Action)
+	}
+	for _, r := range diff.Delete {
+		logger.InfoContext(sinksOnly, "Rule deleted", "target", key, "rule", r.Description, "direction", r.Direction, "protocol", r.Protocol, "port", r.Port, "source", r.source(), "action", r.Action)
+	}
+	fmt.Printf("Successfully applied %s\n", file)
+}
+
+// 读取并校验规则文件，格式与 qcip rules export 导出的 json 或 yaml 相同
+func readRulesFile(file string, configData Config) ([]firewallRule, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var inventory ruleInventory
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err = dec.Decode(&inventory); err != nil {
+		return nil, err
+	}
+	if key := targetKey(configData); inventory.Target != "" && inventory.Target != key {
+		return nil, errors.New("the file is for target " + inventory.Target + ", but the config is for " + key)
+	}
+	var errs []string
+	for i := range inventory.Rules {
+		if err := normalizeRule(&inventory.Rules[i], configData.MType); err != nil {
+			errs = append(errs, "rules["+strconv.Itoa(i)+"]: "+err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.New(strings.Join(errs, "\n  "))
+	}
+	return inventory.Rules, nil
+}
+
+// 填充默认值并检查规则是否可用于该类型的目标
+func normalizeRule(r *firewallRule, mType string) error {
+	if r.Direction == "" {
+		r.Direction = "ingress"
+	}
+	if r.Action == "" {
+		r.Action = "ACCEPT"
+	}
+	r.Direction = strings.ToLower(r.Direction)
+	r.Action = strings.ToUpper(r.Action)
+	r.Protocol = strings.ToUpper(r.Protocol)
+	sources := 0
+	for _, v := range []string{r.CIDR, r.SecurityGroupId, r.AddressTemplate} {
+		if v != "" {
+			sources++
+		}
+	}
+	switch {
+	case mType != "cvm" && (r.SecurityGroupId != "" || r.AddressTemplate != "" || r.ServiceTemplate != ""):
+		return errors.New("securityGroupId, addressTemplate and serviceTemplate are only supported for security groups")
+	case r.ServiceTemplate == "" && r.Protocol == "":
+		return errors.New("protocol is required")
+	case r.ServiceTemplate != "" && (r.Protocol != "" || r.Port != ""):
+		return errors.New("protocol and port cannot be used with serviceTemplate")
+	case r.ServiceTemplate != "" && !strings.HasPrefix(r.ServiceTemplate, "ppm-") && !strings.HasPrefix(r.ServiceTemplate, "ppmg-"):
+		return errors.New("serviceTemplate " + r.ServiceTemplate + " should be a ppm- or ppmg- id")
+	case sources == 0:
+		return errors.New("cidr is required, use " + selfPlaceholder + " for the current ip")
+	case sources > 1:
+		return errors.New("only one of cidr, securityGroupId and addressTemplate can be set")
+	case r.SecurityGroupId != "" && !qcSecurityGroupIdRe.MatchString(r.SecurityGroupId):
+		return errors.New("securityGroupId " + r.SecurityGroupId + " is not a valid security group id")
+	case r.AddressTemplate != "" && !strings.HasPrefix(r.AddressTemplate, "ipm-") && !strings.HasPrefix(r.AddressTemplate, "ipmg-"):
+		return errors.New("addressTemplate " + r.AddressTemplate + " should be an ipm- or ipmg- id")
+	case r.Direction != "ingress" && r.Direction != "egress":
+		return errors.New("direction must be ingress or egress")
+	case r.Direction == "egress" && mType != "cvm":
+		return errors.New("egress rules are only supported for security groups")
+	case r.Action != "ACCEPT" && r.Action != "DROP":
+		return errors.New("action must be ACCEPT or DROP")
+	case r.Action == "DROP" && mType == "allh":
+		return errors.New("Alibaba Cloud Lighthouse only supports ACCEPT")
+	}
+	if r.CIDR != "" && r.CIDR != selfPlaceholder {
+		addr, err := netip.ParseAddr(r.CIDR)
+		if prefix, perr := netip.ParsePrefix(r.CIDR); perr == nil {
+			addr, err = prefix.Addr(), nil
+		}
+		if err != nil {
+			return errors.New("cidr " + r.CIDR + " is not an ip address or cidr")
+		}
+		if mType != "cvm" && addr.Unmap().Is6() {
+			return errors.New("ipv6 cidr " + r.CIDR + " is only supported for security groups")
+		}
+	}
+	return nil
+}
+
+// 检查目标上的规则是否都能在规则文件中表示
+// 腾讯云轻量应用服务器的 SDK 不返回 IPv6 规则的地址，这类规则的 CIDR 为空
+func checkExpressible(rules []firewallRule, mType string) error {
+	var errs []string
+	for _, r := range rules {
+		if r.source() != "" {
+			continue
+		}
+		msg := r.Direction + " rule " + strconv.Quote(r.Description) + " has no source address"
+		if mType == "lh" {
+			msg += ", it is probably an ipv6 rule, which is not supported for lighthouse"
+		}
+		errs = append(errs, msg)
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "\n") + "\nthese rules would be deleted, edit them in the console instead")
+	}
+	return nil
+}
+
+// 用于比较规则是否相同，不包含阿里云的规则ID
+func ruleKey(r firewallRule) string {
+	return strings.Join([]string{
+		strings.ToLower(r.Direction),
+		strings.ToUpper(r.Protocol),
+		strings.ToUpper(r.Port),
+		canonicalCIDR(r.CIDR),
+		r.SecurityGroupId,
+		r.AddressTemplate,
+		r.ServiceTemplate,
+		strings.ToUpper(r.Action),
+		r.Description,
+	}, "\x00")
+}
+
+// 统一地址的写法，单个地址视为 /32 或 /128，无法解析时保持原样
+func canonicalCIDR(cidr string) string {
+	if prefix, err := parseIPRange(cidr); err == nil {
+		return prefix.String()
+	}
+	return cidr
+}
+
+// 按多重集合比较规则，checkOrder 为 true 时同时比较每个方向上的顺序
+func diffRules(current, desired []firewallRule, checkOrder bool) ruleDiff {
+	var diff ruleDiff
+	used := make([]bool, len(current))
+	for _, d := range desired {
+		found := false
+		for i, c := range current {
+			if !used[i] && ruleKey(c) == ruleKey(d) {
+				used[i] = true
+				found = true
+				diff.Keep = append(diff.Keep, c)
+				break
+			}
+		}
+		if !found {
+			diff.Add = append(diff.Add, d)
+		}
+	}
+	for i, c := range current {
+		if !used[i] {
+			diff.Delete = append(diff.Delete, c)
+		}
+	}
+	if checkOrder && len(diff.Add) == 0 && len(diff.Delete) == 0 {
+		for _, direction := range []string{"ingress", "egress"} {
+			c, d := ruleKeys(current, direction), ruleKeys(desired, direction)
+			for i := range c {
+				if c[i] != d[i] {
+					diff.OrderChanged = true
+				}
+			}
+		}
+	}
+	return diff
+}
+
+func ruleKeys(rules []firewallRule, direction string) []string {
+	var keys []string
+	for _, r := range rules {
+		if strings.EqualFold(r.Direction, direction) {
+			keys = append(keys, ruleKey(r))
+		}
+	}
+	return keys
+}
+
+func printRuleDiff(diff ruleDiff) {
+	line := func(mark string, r firewallRule) string {
+		return fmt.Sprintf("  %s %s %s %s  %s  %s", mark, r.Direction, r.service(), r.Action, r.source(), r.Description)
+	}
+	for _, r := range diff.Keep {
+		fmt.Println(line("=", r))
+	}
+	for _, r := range diff.Add {
+		fmt.Printf("\033[32m%s\033[0m\n", line("+", r))
+	}
+	for _, r := range diff.Delete {
+		fmt.Printf("\033[31m%s\033[0m\n", line("-", r))
+	}
+	if diff.OrderChanged {
+		fmt.Printf("\033[33m  ~ the rules will be reordered\033[0m\n")
+	}
+	if diff.empty() {
+		fmt.Println("Plan: nothing to change, the rules already match")
+		return
+	}
+	fmt.Printf("Plan: %d rule(s) to add, %d to delete, %d unchanged\n", len(diff.Add), len(diff.Delete), len(diff.Keep))
+}
+
+// 修改目标上的规则，腾讯云接口会重置全部规则，阿里云逐条添加和删除
+func replaceRules(configData Config, cred cloudCredential, desired []firewallRule, diff ruleDiff) error {
+	switch configData.MType {
+	case "lh":
+		credential := common.NewTokenCredential(cred.SecretId, cred.SecretKey, cred.Token)
+		return QClhReplaceRules(credential, configData.InstanceRegion, configData.InstanceId, desired)
+	case "cvm":
+		credential := common.NewTokenCredential(cred.SecretId, cred.SecretKey, cred.Token)
+		return QCcvmReplaceRules(credential, configData.SecurityGroupId, configData.SecurityGroupRegion, desired, diff)
+	}
+	client, err := ALCreateClient(tea.String(cred.SecretId), tea.String(cred.SecretKey), tea.String(cred.Token))
+	if err != nil {
+		return err
+	}
+	// 先添加再删除，避免修改过程中无法访问
+	return ALlhReplaceRules(client, configData.InstanceRegion, configData.InstanceId, diff.Add, diff.Delete)
+}
+
+func QClhReplaceRules(credential *common.Credential, InstanceRegion string, InstanceId string, rules []firewallRule) error {
+	ptrRules := make([]*qc_lighthouse.FirewallRule, len(rules))
+	for i, r := range rules {
+		ptrRules[i] = &qc_lighthouse.FirewallRule{
+			Protocol:                common.StringPtr(r.Protocol),
+			Port:                    common.StringPtr(r.Port),
+			CidrBlock:               common.StringPtr(r.CIDR),
+			Action:                  common.StringPtr(r.Action),
+			FirewallRuleDescription: common.StringPtr(r.Description),
+		}
+	}
+	client, _ := qc_lighthouse.NewClient(credential, InstanceRegion, QCClientProfile("lighthouse.tencentcloudapi.com"))
+	client.WithHttpTransport(apiTransport)
+	request := qc_lighthouse.NewModifyFirewallRulesRequest()
+	request.InstanceId = common.StringPtr(InstanceId)
+	request.FirewallRules = ptrRules
+	return retrier.do(func() error {
+		_, err := client.ModifyFirewallRules(request)
+		return err
+	})
+}
+
+// 传入生成计划时的版本，期间安全组被其他人修改时腾讯云会拒绝修改
+func QCcvmReplaceRules(credential *common.Credential, SecurityGroupId string, SecurityGroupRegion string, rules []firewallRule, diff ruleDiff) error {
+	client, _ := qc_vpc.NewClient(credential, SecurityGroupRegion, QCClientProfile("vpc.tencentcloudapi.com"))
+	client.WithHttpTransport(apiTransport)
+	if len(rules) == 0 {
+		// Version 为 0 时会不检查版本直接清空，因此按照索引删除，每次只能删除一个方向，版本随每次修改加 1
+		return QCcvmDeleteRules(client, SecurityGroupId, diff.Delete, diff.Version)
+	}
+	set := &qc_vpc.SecurityGroupPolicySet{
+		Ingress: []*qc_vpc.SecurityGroupPolicy{},
+		Egress:  []*qc_vpc.SecurityGroupPolicy{},
+	}
+	// 版本 0 表示清空所有规则，不能传回
+	if diff.Version != "" && diff.Version != "0" {
+		set.Version = common.StringPtr(diff.Version)
+	}
+	for _, r := range rules {
+		policy := &qc_vpc.SecurityGroupPolicy{
+			Action:            common.StringPtr(r.Action),
+			PolicyDescription: common.StringPtr(r.Description),
+		}
+		if r.Protocol != "" {
+			policy.Protocol = common.StringPtr(r.Protocol)
+		}
+		if r.Port != "" {
+			policy.Port = common.StringPtr(r.Port)
+		}
+		switch {
+		case r.ServiceTemplate == "":
+		case strings.HasPrefix(r.ServiceTemplate, "ppmg-"):
+			policy.ServiceTemplate = &qc_vpc.ServiceTemplateSpecification{ServiceGroupId: common.StringPtr(r.ServiceTemplate)}
+		default:
+			policy.ServiceTemplate = &qc_vpc.ServiceTemplateSpecification{ServiceId: common.StringPtr(r.ServiceTemplate)}
+		}
+		switch {
+		case r.SecurityGroupId != "":
+			policy.SecurityGroupId = common.StringPtr(r.SecurityGroupId)
+		case strings.HasPrefix(r.AddressTemplate, "ipmg-"):
+			policy.AddressTemplate = &qc_vpc.AddressTemplateSpecification{AddressGroupId: common.StringPtr(r.AddressTemplate)}
+		case r.AddressTemplate != "":
+			policy.AddressTemplate = &qc_vpc.AddressTemplateSpecification{AddressId: common.StringPtr(r.AddressTemplate)}
+		case strings.Contains(r.CIDR, ":"):
+			policy.Ipv6CidrBlock = common.StringPtr(r.CIDR)
+		default:
+			policy.CidrBlock = common.StringPtr(r.CIDR)
+		}
+		if r.Direction == "egress" {
+			set.Egress = append(set.Egress, policy)
+		} else {
+			set.Ingress = append(set.Ingress, policy)
+		}
+	}
+	request := qc_vpc.NewModifySecurityGroupPoliciesRequest()
+	request.SecurityGroupId = common.StringPtr(SecurityGroupId)
+	request.SecurityGroupPolicySet = set
+	return retrier.do(func() error {
+		_, err := client.ModifySecurityGroupPolicies(request)
+		return err
+	})
+}
+
+// 按照规则索引删除安全组的全部规则
+func QCcvmDeleteRules(client *qc_vpc.Client, SecurityGroupId string, rules []firewallRule, version string) error {
+	v, err := strconv.ParseInt(version, 10, 64)
+	if err != nil {
+		return errors.New("unexpected security group version " + strconv.Quote(version))
+	}
+	for _, direction := range []string{"ingress", "egress"} {
+		set := &qc_vpc.SecurityGroupPolicySet{Version: common.StringPtr(strconv.FormatInt(v, 10))}
+		for _, r := range rules {
+			if r.Direction != direction {
+				continue
+			}
+			index, err := strconv.ParseInt(r.ruleID, 10, 64)
+			if err != nil {
+				return errors.New("rule " + strconv.Quote(r.Description) + " has no policy index")
+			}
+			policy := &qc_vpc.SecurityGroupPolicy{PolicyIndex: common.Int64Ptr(index)}
+			if direction == "egress" {
+				set.Egress = append(set.Egress, policy)
+			} else {
+				set.Ingress = append(set.Ingress, policy)
+			}
+		}
+		if len(set.Ingress)+len(set.Egress) == 0 {
+			continue
+		}
+		request := qc_vpc.NewDeleteSecurityGroupPoliciesRequest()
+		request.SecurityGroupId = common.StringPtr(SecurityGroupId)
+		request.SecurityGroupPolicySet = set
+		if err = retrier.do(func() error {
+			_, err := client.DeleteSecurityGroupPolicies(request)
+			return err
+		}); err != nil {
+			return err
+		}
+		v++
+	}
+	return nil
+}
+
+func ALlhReplaceRules(client *al_swas_open.Client, InstanceRegion string, InstanceId string, add []firewallRule, del []firewallRule) error {
+	runtime := &al_util.RuntimeOptions{}
+	if len(add) > 0 {
+		createRules := make([]*al_swas_open.CreateFirewallRulesRequestFirewallRules, len(add))
+		for i, r := range add {
+			createRules[i] = &al_swas_open.CreateFirewallRulesRequestFirewallRules{
+				RuleProtocol: tea.String(r.Protocol),
+				Port:         tea.String(r.Port),
+				SourceCidrIp: tea.String(r.CIDR),
+				Remark:       tea.String(r.Description),
+			}
+		}
+		createFirewallRulesRequest := &al_swas_open.CreateFirewallRulesRequest{
+			InstanceId:    tea.String(InstanceId),
+			RegionId:      tea.String(InstanceRegion),
+			FirewallRules: createRules,
+		}
+		err := retrier.do(func() error {
+			_, err := client.CreateFirewallRulesWithOptions(createFirewallRulesRequest, runtime)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for _, r := range del {
+		deleteFirewallRuleRequest := &al_swas_open.DeleteFirewallRuleRequest{
+			InstanceId: tea.String(InstanceId),
+			RegionId:   tea.String(InstanceRegion),
+			RuleId:     tea.String(r.ruleID),
+		}
+		err := retrier.do(func() error {
+			_, err := client.DeleteFirewallRuleWithOptions(deleteFirewallRuleRequest, runtime)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
+)
+
+func TestNormalizeRule(t *testing.T) {
+	tests := []struct {
+		name  string
+		mType string
+		rule  firewallRule
+		err   string
+	}{
+		{"cidr", "lh", firewallRule{Protocol: "tcp", Port: "22", CIDR: "@self"}, ""},
+		{"ipv6 security group", "cvm", firewallRule{Protocol: "TCP", Port: "22", CIDR: "2001:db8::/32"}, ""},
+		{"ipv6 lighthouse", "lh", firewallRule{Protocol: "TCP", Port: "22", CIDR: "2001:db8::/32"}, "only supported for security groups"},
+		{"security group source", "cvm", firewallRule{Protocol: "ALL", SecurityGroupId: "sg-abcdefgh"}, ""},
+		{"address template", "cvm", firewallRule{Protocol: "TCP", Port: "443", AddressTemplate: "ipmg-abcdefgh"}, ""},
+		{"service template", "cvm", firewallRule{ServiceTemplate: "ppm-abcdefgh", CIDR: "10.0.0.0/8"}, ""},
+		{"template on lighthouse", "lh", firewallRule{Protocol: "TCP", AddressTemplate: "ipm-abcdefgh"}, "only supported for security groups"},
+		{"two sources", "cvm", firewallRule{Protocol: "TCP", CIDR: "10.0.0.0/8", SecurityGroupId: "sg-abcdefgh"}, "only one of"},
+		{"no source", "cvm", firewallRule{Protocol: "TCP"}, "cidr is required"},
+		{"service template with port", "cvm", firewallRule{ServiceTemplate: "ppm-abcdefgh", Port: "22", CIDR: "10.0.0.0/8"}, "cannot be used with serviceTemplate"},
+		{"bad address template", "cvm", firewallRule{Protocol: "TCP", AddressTemplate: "sg-abcdefgh"}, "ipm- or ipmg-"},
+	}
+	for _, tt := range tests {
+		r := tt.rule
+		err := normalizeRule(&r, tt.mType)
+		if tt.err == "" && err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		} else if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("%s: got %v, want error containing %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestDiffRulesKeepsTemplateRules(t *testing.T) {
+	current := []firewallRule{
+		{Direction: "ingress", Protocol: "TCP", Port: "22", CIDR: "1.2.3.4", Action: "ACCEPT", Description: "ssh"},
+		{Direction: "ingress", ServiceTemplate: "ppmg-abcdefgh", AddressTemplate: "ipm-abcdefgh", Action: "ACCEPT", Description: "office"},
+		{Direction: "ingress", Protocol: "ALL", SecurityGroupId: "sg-abcdefgh", Action: "ACCEPT", Description: "peer"},
+	}
+	desired := append([]firewallRule(nil), current...)
+	if diff := diffRules(current, desired, true); !diff.empty() {
+		t.Fatalf("template rules are not kept: %+v", diff)
+	}
+	desired[2].SecurityGroupId = "sg-12345678"
+	if diff := diffRules(current, desired, true); len(diff.Add) != 1 || len(diff.Delete) != 1 {
+		t.Fatalf("changed security group source is not detected: %+v", diff)
+	}
+}
+
+func TestCheckExpressible(t *testing.T) {
+	rules := []firewallRule{
+		{Direction: "ingress", Protocol: "TCP", Port: "22", CIDR: "1.2.3.4", Description: "ssh"},
+		{Direction: "ingress", Protocol: "TCP", Port: "22", Description: "ssh-v6"},
+	}
+	if err := checkExpressible(rules[:1], "lh"); err != nil {
+		t.Fatal(err)
+	}
+	err := checkExpressible(rules, "lh")
+	if err == nil || !strings.Contains(err.Error(), `"ssh-v6"`) {
+		t.Fatalf("got %v, want an error for the rule without a source", err)
+	}
+}
+
+func TestRuleKeyCanonicalCIDR(t *testing.T) {
+	tests := []struct {
+		a, b string
+		same bool
+	}{
+		{"1.2.3.4", "1.2.3.4/32", true},
+		{"1.2.3.0/24", "1.2.3.0/24", true},
+		{"1.2.3.4/24", "1.2.3.0/24", true},
+		{"2001:db8::1", "2001:DB8:0:0::1/128", true},
+		{"2001:db8::/32", "2001:0db8::/32", true},
+		{"0.0.0.0/0", "0.0.0.0/0", true},
+		{"1.2.3.4", "1.2.3.5", false},
+		{"1.2.3.4/32", "1.2.3.4/31", false},
+		{"::/0", "0.0.0.0/0", false},
+	}
+	for _, tt := range tests {
+		a := firewallRule{Direction: "ingress", Protocol: "TCP", Port: "22", CIDR: tt.a, Action: "ACCEPT", Description: "ssh"}
+		b := a
+		b.CIDR = tt.b
+		if same := ruleKey(a) == ruleKey(b); same != tt.same {
+			t.Errorf("%s and %s: same %v, want %v", tt.a, tt.b, same, tt.same)
+		}
+		if diff := diffRules([]firewallRule{a}, []firewallRule{b}, true); diff.empty() != tt.same {
+			t.Errorf("%s and %s: diff %+v", tt.a, tt.b, diff)
+		}
+	}
+}
+
+// 模拟腾讯云 vpc 接口，记录每个请求的接口名和内容
+func newFakeVPC(t *testing.T) func() []string {
+	var (
+		mu       sync.Mutex
+		requests []string
+	)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		requests = append(requests, r.Header.Get("X-TC-Action")+" "+string(body))
+		mu.Unlock()
+		io.WriteString(w, `{"Response":{"RequestId":"test"}}`)
+	}))
+	t.Cleanup(server.Close)
+	saved := apiTransport
+	apiTransport = &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "tcp", server.Listener.Addr().String())
+		},
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}
+	t.Cleanup(func() { apiTransport = saved })
+	return func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), requests...)
+	}
+}
+
+func TestQCcvmReplaceRulesPassesVersion(t *testing.T) {
+	requests := newFakeVPC(t)
+	credential := common.NewCredential("AKIDtest", "test")
+	rules := []firewallRule{{Direction: "ingress", Protocol: "TCP", Port: "22", CIDR: "1.2.3.4", Action: "ACCEPT", Description: "ssh"}}
+	if err := QCcvmReplaceRules(credential, "sg-abcdefgh", "ap-guangzhou", rules, ruleDiff{Version: "7"}); err != nil {
+		t.Fatal(err)
+	}
+	got := requests()
+	if len(got) != 1 || !strings.HasPrefix(got[0], "ModifySecurityGroupPolicies ") {
+		t.Fatalf("requests %q", got)
+	}
+	var req struct {
+		SecurityGroupPolicySet struct {
+			Version string
+			Ingress []struct{ CidrBlock string }
+		}
+	}
+	json.Unmarshal([]byte(strings.TrimPrefix(got[0], "ModifySecurityGroupPolicies ")), &req)
+	if req.SecurityGroupPolicySet.Version != "7" || len(req.SecurityGroupPolicySet.Ingress) != 1 {
+		t.Fatalf("request %s does not carry version 7", got[0])
+	}
+}
+
+func TestQCcvmReplaceRulesClearByIndex(t *testing.T) {
+	requests := newFakeVPC(t)
+	credential := common.NewCredential("AKIDtest", "test")
+	current := []firewallRule{
+		{Direction: "ingress", Protocol: "TCP", Port: "22", CIDR: "1.2.3.4", Action: "ACCEPT", Description: "ssh", ruleID: "0"},
+		{Direction: "ingress", Protocol: "TCP", Port: "80", CIDR: "0.0.0.0/0", Action: "ACCEPT", Description: "web", ruleID: "1"},
+		{Direction: "egress", Protocol: "ALL", CIDR: "0.0.0.0/0", Action: "ACCEPT", Description: "out", ruleID: "0"},
+	}
+	if err := QCcvmReplaceRules(credential, "sg-abcdefgh", "ap-guangzhou", nil, ruleDiff{Delete: current, Version: "7"}); err != nil {
+		t.Fatal(err)
+	}
+	got := requests()
+	want := []string{
+		`DeleteSecurityGroupPolicies {"SecurityGroupId":"sg-abcdefgh","SecurityGroupPolicySet":{"Version":"7","Ingress":[{"PolicyIndex":0},{"PolicyIndex":1}]}}`,
+		`DeleteSecurityGroupPolicies {"SecurityGroupId":"sg-abcdefgh","SecurityGroupPolicySet":{"Version":"8","Egress":[{"PolicyIndex":0}]}}`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("requests\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// 支持引用的字段，代理链接中可能包含用户名密码
+var secretFields = []string{"SecretId", "SecretKey", "SessionToken", "IPProxy", "APIProxy"}
+
+// 密钥未填写时读取的标准环境变量
+var secretEnvFallback = map[string]map[string]string{
+	"lh":   {"SecretId": "TENCENTCLOUD_SECRET_ID", "SecretKey": "TENCENTCLOUD_SECRET_KEY"},
+	"cvm":  {"SecretId": "TENCENTCLOUD_SECRET_ID", "SecretKey": "TENCENTCLOUD_SECRET_KEY"},
+	"allh": {"SecretId": "ALIBABA_CLOUD_ACCESS_KEY_ID", "SecretKey": "ALIBABA_CLOUD_ACCESS_KEY_SECRET"},
+}
+
+var secretVarRe = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// 解析配置值中的引用
+// ${VAR} 展开为环境变量；env:NAME 读取环境变量；file:/path 读取文件内容，例如 systemd credentials 或 Kubernetes secret
+// file: 中的相对路径相对于 baseDir，即配置文件所在的目录
+func resolveSecret(value string, baseDir string) (string, error) {
+	var expandErr error
+	value = secretVarRe.ReplaceAllStringFunc(value, func(ref string) string {
+		name := secretVarRe.FindStringSubmatch(ref)[1]
+		v, ok := os.LookupEnv(name)
+		if !ok && expandErr == nil {
+			expandErr = errors.New("environment variable " + name + " is not set")
+		}
+		return v
+	})
+	if expandErr != nil {
+		return "", expandErr
+	}
+	if strings.HasPrefix(value, "env:") {
+		name := strings.TrimPrefix(value, "env:")
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			return "", errors.New("environment variable " + name + " is not set")
+		}
+		return v, nil
+	}
+	if strings.HasPrefix(value, "file:") {
+		path := strings.TrimPrefix(value, "file:")
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(baseDir, path)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+	return value, nil
+}
+
+// 解析配置中密钥和代理字段的引用，并在密钥未填写时读取标准环境变量
+func resolveConfigSecrets(configData *Config, root *configNode, baseDir string) []configError {
+	var errs []configError
+	v := reflect.ValueOf(configData).Elem()
+	for _, name := range secretFields {
+		field := v.FieldByName(name)
+		resolved, err := resolveSecret(field.String(), baseDir)
+		if err != nil {
+			errs = append(errs, configError{Line: root.lineOf(name), Path: name, Msg: err.Error()})
+			continue
+		}
+		field.SetString(resolved)
+	}
+	for key, env := range secretEnvFallback[configData.MType] {
+		field := v.FieldByName(key)
+		if field.String() == "" {
+			field.SetString(os.Getenv(env))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveConfigSecrets(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "qcip_key"), []byte("file-secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("QCIP_TEST_ID", "env-id")
+	data := `{"MType":"lh","SecretId":"env:QCIP_TEST_ID","SecretKey":"file:qcip_key","InstanceId":"lhins-abcdefgh","InstanceRegion":"ap-guangzhou","Rules":["env:ssh","${HOME}"]}`
+	configData, errs := parseConfig([]byte(data), "json", "", dir)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if configData.SecretId != "env-id" {
+		t.Errorf("SecretId = %q, want env-id", configData.SecretId)
+	}
+	// 相对路径相对于配置文件所在的目录
+	if configData.SecretKey != "file-secret" {
+		t.Errorf("SecretKey = %q, want file-secret", configData.SecretKey)
+	}
+	// 其他字段不解析引用
+	if configData.Rules[0] != "env:ssh" || configData.Rules[1] != "${HOME}" {
+		t.Errorf("Rules = %q, want them unchanged", configData.Rules)
+	}
+}
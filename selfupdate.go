@@ -0,0 +1,389 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+const (
+	checksumsFile     = "sha256sums.txt"
+	signatureFile     = checksumsFile + ".minisig"
+	releaseDownload   = "https://github.com/cnlancehu/qcip/releases/download/"
+	maxBinarySize     = 256 << 20 // 解压出的程序大小上限
+	maxChecksumsSize  = 1 << 20
+	maxSignatureSize  = 4 << 10
+	downloadTimeout   = 10 * time.Minute
+	verifyExecTimeout = 30 * time.Second
+)
+
+// 校验发布签名的 minisign 公钥，构建时通过 -X main.updatePublicKey 写入
+var updatePublicKey string
+
+var forceUpdate bool // self-update --force
+
+// 下载发布文件的客户端，程序较大，不使用检查更新的 10 秒超时
+var downloadClient = &http.Client{Timeout: downloadTimeout}
+
+// 当前平台的发布包名称，与 build.sh 一致
+func releaseArchiveName() (archive string, binary string) {
+	if goos == "windows" {
+		return "qcip-" + goos + "-" + goarch + ".zip", "qcip.exe"
+	}
+	return "qcip-" + goos + "-" + goarch + ".tar.gz", "qcip"
+}
+
+func selfUpdateCommand() {
+	fail := func(msg string, err error) {
+		errOutput("Self-update failed: " + msg)
+		if err != nil {
+			errDetail(err)
+		}
+		errExit()
+	}
+	if updatePublicKey == "" {
+		fail("this build has no update signing key, download the new version manually", nil)
+	}
+	channel, err := resolveUpdateChannel()
+	if err != nil {
+		fail("invalid update channel", err)
+	}
+	exe, err := os.Executable()
+	if err == nil {
+		exe, err = filepath.EvalSymlinks(exe)
+	}
+	if err != nil {
+		fail("cannot locate the running program", err)
+	}
+
+	fmt.Printf("Checking for update...")
+	latest, newer, err := checkUpdate(channel)
+	if err != nil {
+		fmt.Printf("\r\033[31mFailed to check updates\033[0m\n")
+		fail("cannot get the latest release from "+updateEndpoint(), err)
+	}
+	if !newer && !forceUpdate {
+		fmt.Printf("\r\033[1;32mYou are using the latest %s version\033[0m\n", channel)
+		return
+	}
+	fmt.Printf("\rUpdating from \033[1;33mv%s\033[0m to \033[1;32m%s\033[0m\n", version, latest.TagName)
+
+	if msg, err := installRelease(latest, exe); err != nil {
+		fail(msg, err)
+	}
+	logger.Info("Updated", "from", version, "to", latest.TagName, "path", exe)
+}
+
+// 下载、校验并安装 release，失败时返回出错的步骤
+func installRelease(release releaseInfo, exe string) (string, error) {
+	archiveName, binaryName := releaseArchiveName()
+	fmt.Printf("Verifying %s\n", checksumsFile)
+	checksums, err := downloadAsset(release, checksumsFile, maxChecksumsSize)
+	if err != nil {
+		return "cannot download " + checksumsFile, err
+	}
+	signature, err := downloadAsset(release, signatureFile, maxSignatureSize)
+	if err != nil {
+		return "cannot download " + signatureFile, err
+	}
+	if err = verifyMinisign(updatePublicKey, checksums, signature); err != nil {
+		return "signature of " + checksumsFile + " is invalid", err
+	}
+	sum, err := lookupChecksum(checksums, archiveName)
+	if err != nil {
+		return "no checksum for " + archiveName, err
+	}
+
+	fmt.Printf("Downloading %s\n", archiveName)
+	archive, err := downloadArchive(release, archiveName, filepath.Dir(exe), sum)
+	if err != nil {
+		return "cannot download " + archiveName, err
+	}
+	defer func() {
+		archive.Close()
+		os.Remove(archive.Name())
+	}()
+	newExe, err := extractBinary(archive, archiveName, binaryName, exe)
+	if err != nil {
+		return "cannot extract " + binaryName + " from " + archiveName, err
+	}
+	defer os.Remove(newExe)
+
+	if err = replaceExecutable(exe, newExe, release.TagName); err != nil {
+		return "cannot install " + release.TagName + ", the current version is kept", err
+	}
+	return "", nil
+}
+
+// 发布文件的下载地址，发布信息中没有时使用 GitHub 的地址
+func assetURL(release releaseInfo, name string) string {
+	for _, a := range release.Assets {
+		if a.Name == name && a.URL != "" {
+			return a.URL
+		}
+	}
+	return releaseDownload + release.TagName + "/" + name
+}
+
+func getAsset(release releaseInfo, name string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", assetURL(release, name), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", ua)
+	resp, err := downloadClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 && resp.StatusCode <= 599 {
+		resp.Body.Close()
+		return nil, &httpStatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+	return resp, nil
+}
+
+func downloadAsset(release releaseInfo, name string, limit int64) ([]byte, error) {
+	resp, err := getAsset(release, name)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, errors.New(name + " is too large")
+	}
+	return data, nil
+}
+
+// 下载发布包到 dir 中的临时文件并校验 SHA-256
+func downloadArchive(release releaseInfo, name string, dir string, sum []byte) (*os.File, error) {
+	resp, err := getAsset(release, name)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	f, err := os.CreateTemp(dir, ".qcip-download-*")
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	if _, err = io.Copy(io.MultiWriter(f, h), resp.Body); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	if got := h.Sum(nil); !bytes.Equal(got, sum) {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, errors.New("checksum mismatch: expected " + hex.EncodeToString(sum) + ", got " + hex.EncodeToString(got))
+	}
+	return f, nil
+}
+
+// 从 sha256sum 的输出中找到 name 的校验值
+func lookupChecksum(checksums []byte, name string) ([]byte, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(checksums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 || strings.TrimPrefix(fields[1], "*") != name {
+			continue
+		}
+		sum, err := hex.DecodeString(fields[0])
+		if err != nil || len(sum) != sha256.Size {
+			return nil, errors.New("malformed checksum line for " + name)
+		}
+		return sum, nil
+	}
+	return nil, errors.New(name + " is not listed in " + checksumsFile)
+}
+
+// 校验 minisign 签名，支持 Ed 和预先哈希的 ED 两种算法
+func verifyMinisign(publicKey string, data []byte, signature []byte) error {
+	pk, err := base64.StdEncoding.DecodeString(strings.TrimSpace(publicKey))
+	if err != nil || len(pk) != 42 || string(pk[:2]) != "Ed" {
+		return errors.New("malformed public key")
+	}
+	lines := strings.Split(strings.ReplaceAll(string(signature), "\r\n", "\n"), "\n")
+	if len(lines) < 4 || !strings.HasPrefix(lines[2], "trusted comment: ") {
+		return errors.New("malformed signature file")
+	}
+	sig, err := base64.StdEncoding.DecodeString(lines[1])
+	if err != nil || len(sig) != 74 {
+		return errors.New("malformed signature")
+	}
+	globalSig, err := base64.StdEncoding.DecodeString(lines[3])
+	if err != nil || len(globalSig) != ed25519.SignatureSize {
+		return errors.New("malformed global signature")
+	}
+	if !bytes.Equal(sig[2:10], pk[2:10]) {
+		return errors.New("signed by a different key")
+	}
+	switch string(sig[:2]) {
+	case "Ed":
+	case "ED":
+		hash := blake2b.Sum512(data)
+		data = hash[:]
+	default:
+		return errors.New("unsupported signature algorithm")
+	}
+	key := ed25519.PublicKey(pk[10:])
+	if !ed25519.Verify(key, data, sig[10:]) {
+		return errors.New("signature verification failed")
+	}
+	trusted := strings.TrimPrefix(lines[2], "trusted comment: ")
+	signed := append(append([]byte{}, sig[10:]...), trusted...)
+	if !ed25519.Verify(key, signed, globalSig) {
+		return errors.New("trusted comment verification failed")
+	}
+	return nil
+}
+
+// 从发布包中解压出程序，写入 exe 所在目录的临时文件，权限与 exe 相同
+func extractBinary(archive *os.File, archiveName string, binaryName string, exe string) (string, error) {
+	var src io.Reader
+	if strings.HasSuffix(archiveName, ".zip") {
+		info, err := archive.Stat()
+		if err != nil {
+			return "", err
+		}
+		zr, err := zip.NewReader(archive, info.Size())
+		if err != nil {
+			return "", err
+		}
+		for _, f := range zr.File {
+			if f.Name == binaryName {
+				rc, err := f.Open()
+				if err != nil {
+					return "", err
+				}
+				defer rc.Close()
+				src = rc
+				break
+			}
+		}
+	} else {
+		if _, err := archive.Seek(0, io.SeekStart); err != nil {
+			return "", err
+		}
+		gz, err := gzip.NewReader(archive)
+		if err != nil {
+			return "", err
+		}
+		defer gz.Close()
+		tr := tar.NewReader(gz)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return "", err
+			}
+			if hdr.Typeflag == tar.TypeReg && hdr.Name == binaryName {
+				src = tr
+				break
+			}
+		}
+	}
+	if src == nil {
+		return "", errors.New(binaryName + " not found in the archive")
+	}
+
+	mode := os.FileMode(0755)
+	if info, err := os.Stat(exe); err == nil {
+		mode = info.Mode().Perm()
+	}
+	f, err := os.CreateTemp(filepath.Dir(exe), ".qcip-new-*")
+	if err != nil {
+		return "", err
+	}
+	n, err := io.Copy(f, io.LimitReader(src, maxBinarySize+1))
+	if err == nil && n > maxBinarySize {
+		err = errors.New(binaryName + " is too large")
+	}
+	if err == nil {
+		err = f.Chmod(mode)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// 用 newExe 替换 exe，新程序无法运行时恢复原来的程序
+func replaceExecutable(exe string, newExe string, tag string) error {
+	old := exe + ".old"
+	os.Remove(old)
+	// Windows 不能删除或覆盖正在运行的程序，但可以重命名
+	if err := os.Rename(exe, old); err != nil {
+		return err
+	}
+	restore := func(cause error) error {
+		os.Remove(exe)
+		if err := os.Rename(old, exe); err != nil {
+			return errors.Join(cause, errors.New("restore failed, the previous version is at "+old+": "+err.Error()))
+		}
+		return cause
+	}
+	if err := os.Rename(newExe, exe); err != nil {
+		return restore(err)
+	}
+	if err := verifyExecutable(exe, tag); err != nil {
+		return restore(err)
+	}
+	os.Remove(old)
+	return nil
+}
+
+// 运行新程序确认可以在当前系统上使用
+func verifyExecutable(exe string, tag string) error {
+	cmd := exec.Command(exe, "version")
+	cmd.Env = append(os.Environ(), noUpdateCheckEnv+"=1")
+	var out bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &out
+	if err := cmd.Start(); err != nil {
+		return errors.New("new version cannot be started: " + err.Error())
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			msg := "new version exited with an error: " + err.Error()
+			if output := strings.TrimSpace(out.String()); output != "" {
+				msg += ": " + output
+			}
+			return errors.New(msg)
+		}
+	case <-time.After(verifyExecTimeout):
+		cmd.Process.Kill()
+		return errors.New("new version did not exit in " + verifyExecTimeout.String())
+	}
+	if !strings.Contains(out.String(), "v"+strings.TrimPrefix(tag, "v")) {
+		return errors.New("new version reports an unexpected version: " + strings.TrimSpace(out.String()))
+	}
+	return nil
+}
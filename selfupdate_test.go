@@ -0,0 +1,227 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// 按照 minisign 的公钥和签名文件格式，用固定种子的密钥对 testMinisignData 签名得到
+// ED 为 minisign 默认的预先哈希签名，Ed 为 minisign -S -l 的旧格式
+const (
+	testMinisignPublicKey = "RWQH9uXUo7LBUXsxLkYTvsgPyL8QSHY9+8dr/c627f6RptDu3URr0qIU"
+	testMinisignData      = "0123abcd  qcip-linux-amd64.tar.gz\n"
+	testMinisignHashed    = "untrusted comment: signature from minisign secret key\n" +
+		"RUQH9uXUo7LBUZlI/GWm1XcZ0hE1//FSD6He4Z3vyBu4rD6iqztkHtsUToXtV4ig3WOMbCi5tbZnUD9xftMLfAQlHFMMh4qhygs=\n" +
+		"trusted comment: timestamp:1760000000\tfile:sha256sums.txt\thashed\n" +
+		"F1ILo1WQG713c2FVW5K3/t3Ln8366hBeRZG1ZOvi+NBhdF47BwZjmFjiHFnNer3zOmYmkqi46avVkzZkjGvBBw==\n"
+	testMinisignLegacy = "untrusted comment: signature from minisign secret key\n" +
+		"RWQH9uXUo7LBUYWSJujS7FYCAx8zalDuXB3KR5UCGvFidfB19lPJWAdD14Quxb1A5ydawlmBIrQJ6mr5VveLkH3ehJhvLRF+lQ4=\n" +
+		"trusted comment: timestamp:1760000000\tfile:sha256sums.txt\thashed\n" +
+		"pgXZuEUs5tVQdDQXeBnIshWNAcHOOH0Pq34S/jNSlp/gBAPTd+KNbosX+oFcWU1s5n5m+vXhbcLAs3IEcoW9DQ==\n"
+)
+
+func TestVerifyMinisign(t *testing.T) {
+	otherKey := "RWQH9uXUo7LBUXsxLkYTvsgPyL8QSHY9+8dr/c627f6RptDu3URr0qIV"
+	tests := []struct {
+		name, key, data, sig, wantErr string
+	}{
+		{"prehashed", testMinisignPublicKey, testMinisignData, testMinisignHashed, ""},
+		{"legacy", testMinisignPublicKey, testMinisignData, testMinisignLegacy, ""},
+		{"crlf", testMinisignPublicKey, testMinisignData, strings.ReplaceAll(testMinisignHashed, "\n", "\r\n"), ""},
+		{"tampered data", testMinisignPublicKey, strings.Replace(testMinisignData, "0123", "0124", 1), testMinisignHashed, "signature verification failed"},
+		{"tampered trusted comment", testMinisignPublicKey, testMinisignData, strings.Replace(testMinisignHashed, "1760000000", "1760000001", 1), "trusted comment verification failed"},
+		{"different key id", "RWQAAAAAAAAAAHsxLkYTvsgPyL8QSHY9+8dr/c627f6RptDu3URr0qIU", testMinisignData, testMinisignHashed, "signed by a different key"},
+		{"different key", otherKey, testMinisignData, testMinisignHashed, "signature verification failed"},
+		{"malformed public key", "RWQ=", testMinisignData, testMinisignHashed, "malformed public key"},
+		{"malformed signature file", testMinisignPublicKey, testMinisignData, "untrusted comment: x\n", "malformed signature file"},
+	}
+	for _, tt := range tests {
+		err := verifyMinisign(tt.key, []byte(tt.data), []byte(tt.sig))
+		if tt.wantErr == "" && err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		} else if tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr) {
+			t.Errorf("%s: got %v, want %s", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestLookupChecksum(t *testing.T) {
+	sum := strings.Repeat("ab", 32)
+	checksums := []byte(strings.Repeat("cd", 32) + "  qcip-linux-arm64.tar.gz\n" +
+		sum + " *qcip-windows-amd64.zip\n" +
+		"0123abcd  qcip-linux-amd64.tar.gz\n" +
+		"not a checksum line\n" +
+		sum + "  qcip-darwin-arm64.tar.gz\n")
+	tests := []struct {
+		name, file, want, wantErr string
+	}{
+		{"text mode", "qcip-darwin-arm64.tar.gz", sum, ""},
+		{"binary mode", "qcip-windows-amd64.zip", sum, ""},
+		{"missing", "qcip-freebsd-amd64.tar.gz", "", "qcip-freebsd-amd64.tar.gz is not listed in sha256sums.txt"},
+		{"malformed hash", "qcip-linux-amd64.tar.gz", "", "malformed checksum line for qcip-linux-amd64.tar.gz"},
+	}
+	for _, tt := range tests {
+		got, err := lookupChecksum(checksums, tt.file)
+		if tt.wantErr != "" {
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("%s: got %v, want %s", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || hex.EncodeToString(got) != tt.want {
+			t.Errorf("%s: got %x, %v, want %s", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+// 在 dir 中生成包含 files 的发布包
+func writeTestArchive(t *testing.T, dir string, name string, files map[string]string) *os.File {
+	var buf bytes.Buffer
+	if strings.HasSuffix(name, ".zip") {
+		zw := zip.NewWriter(&buf)
+		for n, content := range files {
+			w, err := zw.Create(n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.Write([]byte(content))
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+	} else {
+		gz := gzip.NewWriter(&buf)
+		tw := tar.NewWriter(gz)
+		for n, content := range files {
+			if err := tw.WriteHeader(&tar.Header{Name: n, Mode: 0755, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+				t.Fatal(err)
+			}
+			tw.Write([]byte(content))
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		gz.Close()
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func TestExtractBinary(t *testing.T) {
+	tests := []struct {
+		archive, binary string
+	}{
+		{"qcip-linux-amd64.tar.gz", "qcip"},
+		{"qcip-windows-amd64.zip", "qcip.exe"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.archive, func(t *testing.T) {
+			dir := t.TempDir()
+			exe := filepath.Join(dir, tt.binary)
+			if err := os.WriteFile(exe, []byte("old"), 0750); err != nil {
+				t.Fatal(err)
+			}
+			archive := writeTestArchive(t, t.TempDir(), tt.archive, map[string]string{
+				"README.md": "readme",
+				tt.binary:   "new binary",
+			})
+			newExe, err := extractBinary(archive, tt.archive, tt.binary, exe)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if filepath.Dir(newExe) != dir {
+				t.Errorf("extracted to %s, want next to %s", newExe, exe)
+			}
+			data, err := os.ReadFile(newExe)
+			if err != nil || string(data) != "new binary" {
+				t.Fatalf("extracted %q, %v", data, err)
+			}
+			if info, _ := os.Stat(newExe); runtime.GOOS != "windows" && info.Mode().Perm() != 0750 {
+				t.Errorf("mode %v, want the mode of the current executable", info.Mode().Perm())
+			}
+
+			archive = writeTestArchive(t, t.TempDir(), tt.archive, map[string]string{"README.md": "readme"})
+			if _, err := extractBinary(archive, tt.archive, tt.binary, exe); err == nil || err.Error() != tt.binary+" not found in the archive" {
+				t.Fatalf("got %v, want the binary reported missing", err)
+			}
+			entries, _ := os.ReadDir(dir)
+			if len(entries) != 2 {
+				t.Fatalf("%d files left in %s, want the old and the first extracted binary", len(entries), dir)
+			}
+		})
+	}
+}
+
+func TestReplaceExecutable(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("stub executables are shell scripts")
+	}
+	dir := t.TempDir()
+	exe := filepath.Join(dir, "qcip")
+	original := []byte("#!/bin/sh\necho qcip v1.0.0\n")
+	if err := os.WriteFile(exe, original, 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(exe, 0750); err != nil {
+		t.Fatal(err)
+	}
+	write := func(script string) string {
+		path := filepath.Join(dir, ".qcip-new-test")
+		if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	checkOriginal := func() {
+		t.Helper()
+		data, err := os.ReadFile(exe)
+		if err != nil || !bytes.Equal(data, original) {
+			t.Fatalf("executable %q, %v, want the original restored", data, err)
+		}
+		if info, _ := os.Stat(exe); info.Mode().Perm() != 0750 {
+			t.Fatalf("mode %v, want 0750", info.Mode().Perm())
+		}
+		if _, err := os.Stat(exe + ".old"); !os.IsNotExist(err) {
+			t.Fatalf("backup left behind: %v", err)
+		}
+	}
+
+	err := replaceExecutable(exe, write("#!/bin/sh\necho broken >&2\nexit 2\n"), "v1.1.0")
+	if err == nil || !strings.Contains(err.Error(), "new version exited with an error") || !strings.Contains(err.Error(), "broken") {
+		t.Fatalf("got %v, want the failed run reported", err)
+	}
+	checkOriginal()
+
+	err = replaceExecutable(exe, write("#!/bin/sh\necho qcip v1.0.0\n"), "v1.1.0")
+	if err == nil || !strings.Contains(err.Error(), "unexpected version") {
+		t.Fatalf("got %v, want the wrong version reported", err)
+	}
+	checkOriginal()
+
+	updated := "#!/bin/sh\necho qcip v1.1.0\n"
+	if err := replaceExecutable(exe, write(updated), "v1.1.0"); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(exe); string(data) != updated {
+		t.Fatalf("executable %q, want the new version", data)
+	}
+	if _, err := os.Stat(exe + ".old"); !os.IsNotExist(err) {
+		t.Fatalf("backup left behind: %v", err)
+	}
+}
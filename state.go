@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const defaultCacheMaxAge = 24 * time.Hour // 默认缓存有效期，超过后即使IP未变化也会重新检查规则
+
+// 本地状态文件，记录每个目标上每条规则最后一次成功应用的IP
+type State struct {
+	Targets map[string]*TargetState `json:"targets"`
+}
+
+type TargetState struct {
+	Rules map[string]RuleState `json:"rules"`
+}
+
+type RuleState struct {
+	IP         string    `json:"ip"`
+	PreviousIP string    `json:"previousIp,omitempty"` // 修改前的地址，用于回滚
+	AppliedAt  time.Time `json:"appliedAt"`
+}
+
+// 状态文件的默认路径
+func defaultStatePath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "qcip-state.json"
+	}
+	return filepath.Join(dir, "qcip", "state.json")
+}
+
+// 目标的唯一标识
+func targetKey(configData Config) string {
+	if configData.MType == "cvm" {
+		return configData.MType + "/" + configData.SecurityGroupRegion + "/" + configData.SecurityGroupId
+	}
+	return configData.MType + "/" + configData.InstanceRegion + "/" + configData.InstanceId
+}
+
+// 读取状态文件，文件不存在时返回空状态
+func loadState(path string) (*State, error) {
+	state := &State{Targets: make(map[string]*TargetState)}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return state, nil
+		}
+		return state, err
+	}
+	if err = json.Unmarshal(data, state); err != nil {
+		return &State{Targets: make(map[string]*TargetState)}, err
+	}
+	if state.Targets == nil {
+		state.Targets = make(map[string]*TargetState)
+	}
+	return state, nil
+}
+
+// 写入状态文件，先写临时文件再重命名，避免中断时留下损坏的文件
+func saveState(path string, state *State) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".qcip-state-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(tmp.Name(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// 判断目标上的所有规则是否已在缓存有效期内应用过该IP，任一规则没有记录时返回 false
+func (s *State) upToDate(key string, rules []string, ip string, maxAge time.Duration) bool {
+	target, ok := s.Targets[key]
+	if !ok || maxAge <= 0 || len(rules) == 0 {
+		return false
+	}
+	for _, rule := range rules {
+		r, ok := target.Rules[rule]
+		if !ok || r.IP != ip || time.Since(r.AppliedAt) > maxAge {
+			return false
+		}
+	}
+	return true
+}
+
+// 记录目标上的规则已成功应用该IP，before 为修改前目标上每条规则的地址
+// 只记录在目标上找到的规则，本次被修改的规则同时记录原来的地址，未被修改的规则保留上一次记录的原地址
+// 规则以描述为标识，before 中描述相同的多条规则只有一个地址，回滚时会恢复为同一个地址
+func (s *State) record(key string, rules []string, ip string, before map[string]string) {
+	now := time.Now()
+	target, ok := s.Targets[key]
+	if !ok {
+		target = &TargetState{Rules: make(map[string]RuleState)}
+		s.Targets[key] = target
+	}
+	if target.Rules == nil {
+		target.Rules = make(map[string]RuleState)
+	}
+	for _, rule := range rules {
+		old, ok := before[rule]
+		if !ok {
+			continue
+		}
+		r := RuleState{IP: ip, PreviousIP: target.Rules[rule].PreviousIP, AppliedAt: now}
+		if old != ip {
+			r.PreviousIP = old
+		}
+		target.Rules[rule] = r
+	}
+}
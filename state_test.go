@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStateRecordSkipsMissingRules(t *testing.T) {
+	state := &State{Targets: make(map[string]*TargetState)}
+	before := map[string]string{"ssh": "1.1.1.1", "web": "2.2.2.2"}
+	state.record("lh/ap-guangzhou/lhins-1", []string{"ssh", "web", "typo"}, "2.2.2.2", before)
+
+	rules := state.Targets["lh/ap-guangzhou/lhins-1"].Rules
+	if _, ok := rules["typo"]; ok {
+		t.Fatal("rule that matched nothing was recorded")
+	}
+	if r := rules["ssh"]; r.IP != "2.2.2.2" || r.PreviousIP != "1.1.1.1" {
+		t.Fatalf("ssh recorded as %+v", r)
+	}
+	if r := rules["web"]; r.IP != "2.2.2.2" || r.PreviousIP != "" {
+		t.Fatalf("web recorded as %+v", r)
+	}
+	// 配置中的规则未全部找到时不能跳过检查
+	if state.upToDate("lh/ap-guangzhou/lhins-1", []string{"ssh", "web", "typo"}, "2.2.2.2", time.Hour) {
+		t.Fatal("upToDate is true although a configured rule has no record")
+	}
+	if !state.upToDate("lh/ap-guangzhou/lhins-1", []string{"ssh", "web"}, "2.2.2.2", time.Hour) {
+		t.Fatal("upToDate is false for recorded rules")
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+)
+
+// qcip status，显示当前IP与每条匹配规则的状态，不修改任何规则
+func statusCommand() {
+	startReport("status")
+	configData := prepareRun()
+	ip := resolveIP(configData)
+	rules, err := fetchFirewallRules(configData, getCredential(configData))
+	if err != nil {
+		errOutput("Error while fetching rules:")
+		errDetail(err)
+		errExit()
+	}
+	changes, missing := matchRules(rules, configData.Rules, ip)
+	report.addChanges(configData, changes, missing)
+	fmt.Printf("Target: %s\nPublic IP: %s\n", targetKey(configData), ip)
+	printStatus(changes, missing)
+	report.finish()
+}
+
+func printStatus(changes []ruleChange, missing []string) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "  RULE\tPROTOCOL\tPORT\tCIDR\tSTATUS")
+	outOfSync := 0
+	for _, c := range changes {
+		r := c.Rule
+		status := "in sync"
+		if !c.inSync() {
+			status = "out of sync"
+			outOfSync++
+		}
+		fmt.Fprintf(w, "  %s\t%s\t%s\t%s\t%s\n", r.Description, r.Protocol, r.Port, r.CIDR, status)
+	}
+	for _, name := range missing {
+		fmt.Fprintf(w, "  %s\t-\t-\t-\tnot found\n", name)
+	}
+	w.Flush()
+	fmt.Printf("%d rule(s) matched, %d in sync, %d out of sync, %d name(s) not found\n", len(changes), len(changes)-outOfSync, outOfSync, len(missing))
+}
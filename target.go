@@ -0,0 +1,168 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/alibabacloud-go/tea/tea"
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
+)
+
+// 与云服务商无关的防火墙规则
+type firewallRule struct {
+	Description string `json:"description" yaml:"description"`
+	Protocol    string `json:"protocol" yaml:"protocol"`
+	Port        string `json:"port" yaml:"port"`
+	CIDR        string `json:"cidr" yaml:"cidr"`
+	Action      string `json:"action" yaml:"action"`
+	Direction   string `json:"direction" yaml:"direction"` // ingress 或 egress
+	// 以下仅用于腾讯云安全组，来源为另一个安全组或参数模板时 CIDR 为空，使用服务模板时 Protocol 和 Port 为空
+	SecurityGroupId string `json:"securityGroupId,omitempty" yaml:"securityGroupId,omitempty"`
+	AddressTemplate string `json:"addressTemplate,omitempty" yaml:"addressTemplate,omitempty"` // ipm- 或 ipmg-
+	ServiceTemplate string `json:"serviceTemplate,omitempty" yaml:"serviceTemplate,omitempty"` // ppm- 或 ppmg-
+	ruleID          string // 阿里云删除规则时使用的规则ID，腾讯云安全组为规则的索引
+}
+
+// 规则的来源，引用安全组或参数模板时为其 ID
+func (r firewallRule) source() string {
+	if r.SecurityGroupId != "" {
+		return r.SecurityGroupId
+	}
+	if r.AddressTemplate != "" {
+		return r.AddressTemplate
+	}
+	return r.CIDR
+}
+
+// 规则的协议和端口，使用服务模板时为模板 ID
+func (r firewallRule) service() string {
+	if r.ServiceTemplate != "" {
+		return r.ServiceTemplate
+	}
+	return r.Protocol + " " + r.Port
+}
+
+// 一条匹配到的规则及其应当使用的地址
+type ruleChange struct {
+	Rule    firewallRule
+	NewCIDR string
+}
+
+func (c ruleChange) inSync() bool {
+	return c.Rule.CIDR == c.NewCIDR
+}
+
+// 获取目标上的全部规则
+func fetchFirewallRules(configData Config, cred cloudCredential) ([]firewallRule, error) {
+	rules, _, err := fetchRuleSet(configData, cred)
+	return rules, err
+}
+
+// 获取目标上的全部规则，以及腾讯云安全组规则的版本，修改时传回版本以免覆盖期间的其他修改
+func fetchRuleSet(configData Config, cred cloudCredential) ([]firewallRule, string, error) {
+	var (
+		rules   []firewallRule
+		version string
+	)
+	switch configData.MType {
+	case "lh":
+		credential := common.NewTokenCredential(cred.SecretId, cred.SecretKey, cred.Token)
+		res, err := QClhFetchRules(credential, configData.InstanceRegion, configData.InstanceId)
+		if err != nil {
+			return nil, "", err
+		}
+		for _, r := range res {
+			rules = append(rules, firewallRule{
+				Description: tea.StringValue(r.FirewallRuleDescription),
+				Protocol:    tea.StringValue(r.Protocol),
+				Port:        tea.StringValue(r.Port),
+				CIDR:        tea.StringValue(r.CidrBlock),
+				Action:      tea.StringValue(r.Action),
+				Direction:   "ingress",
+			})
+		}
+	case "cvm":
+		credential := common.NewTokenCredential(cred.SecretId, cred.SecretKey, cred.Token)
+		res, err := QCcvmFetchRules(credential, configData.SecurityGroupId, configData.SecurityGroupRegion)
+		if err != nil {
+			return nil, "", err
+		}
+		if res == nil {
+			return nil, "", nil
+		}
+		version = tea.StringValue(res.Version)
+		for _, direction := range []string{"ingress", "egress"} {
+			set := res.Ingress
+			if direction == "egress" {
+				set = res.Egress
+			}
+			for _, r := range set {
+				cidr := tea.StringValue(r.CidrBlock)
+				if cidr == "" {
+					cidr = tea.StringValue(r.Ipv6CidrBlock)
+				}
+				rule := firewallRule{
+					Description:     tea.StringValue(r.PolicyDescription),
+					Protocol:        tea.StringValue(r.Protocol),
+					Port:            tea.StringValue(r.Port),
+					CIDR:            cidr,
+					Action:          tea.StringValue(r.Action),
+					Direction:       direction,
+					SecurityGroupId: tea.StringValue(r.SecurityGroupId),
+				}
+				if r.PolicyIndex != nil {
+					rule.ruleID = strconv.FormatInt(*r.PolicyIndex, 10)
+				}
+				if t := r.AddressTemplate; t != nil {
+					rule.AddressTemplate = tea.StringValue(t.AddressId) + tea.StringValue(t.AddressGroupId)
+				}
+				if t := r.ServiceTemplate; t != nil {
+					rule.ServiceTemplate = tea.StringValue(t.ServiceId) + tea.StringValue(t.ServiceGroupId)
+				}
+				rules = append(rules, rule)
+			}
+		}
+	case "allh":
+		client, err := ALCreateClient(tea.String(cred.SecretId), tea.String(cred.SecretKey), tea.String(cred.Token))
+		if err != nil {
+			return nil, "", err
+		}
+		res, err := ALlhFetchRules(client, configData.InstanceRegion, configData.InstanceId)
+		if err != nil {
+			return nil, "", err
+		}
+		for _, r := range res {
+			rules = append(rules, firewallRule{
+				Description: tea.StringValue(r.Remark),
+				Protocol:    tea.StringValue(r.RuleProtocol),
+				Port:        tea.StringValue(r.Port),
+				CIDR:        tea.StringValue(r.SourceCidrIp),
+				Action:      "ACCEPT",
+				Direction:   "ingress",
+				ruleID:      tea.StringValue(r.RuleId),
+			})
+		}
+	}
+	return rules, version, nil
+}
+
+// 按照 Rules 匹配入站规则，与 QClhMatch QCcvmMatch ALlhMatch 的匹配方式一致
+// 返回匹配到的规则以及没有匹配到任何规则的名称
+func matchRules(rules []firewallRule, names []string, ip string) ([]ruleChange, []string) {
+	var (
+		changes []ruleChange
+		missing []string
+	)
+	for _, name := range names {
+		found := false
+		for _, r := range rules {
+			if r.Direction == "ingress" && r.Description == name {
+				changes = append(changes, ruleChange{Rule: r, NewCIDR: ip})
+				found = true
+			}
+		}
+		if !found {
+			missing = append(missing, name)
+		}
+	}
+	return changes, missing
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultUpdateEndpoint = "https://api.github.com/repos/cnlancehu/qcip/releases"
+	updateEndpointEnv     = "QCIP_UPDATE_ENDPOINT" // 检查更新的地址，可指向内网镜像
+	updateChannelEnv      = "QCIP_UPDATE_CHANNEL"  // stable 或 beta
+	noUpdateCheckEnv      = "QCIP_NO_UPDATE_CHECK" // 设置后不检查更新，用于无法访问外网的主机
+	releasePageURL        = "https://github.com/cnlancehu/qcip/releases/tag/"
+)
+
+var (
+	updateChannel string // version --channel，为空时使用 QCIP_UPDATE_CHANNEL，默认为 stable
+	noUpdateCheck bool   // version --no-check
+)
+
+var updateChannels = []string{"stable", "beta"}
+
+// 语义化版本，忽略构建信息
+type semver struct {
+	Major, Minor, Patch int
+	Pre                 []string // 预发布标识，例如 beta.1 为 ["beta", "1"]
+}
+
+func parseSemver(v string) (semver, error) {
+	var s semver
+	raw := v
+	v = strings.TrimPrefix(v, "v")
+	if i := strings.IndexByte(v, '+'); i >= 0 {
+		v = v[:i]
+	}
+	if i := strings.IndexByte(v, '-'); i >= 0 {
+		if v[i+1:] == "" {
+			return s, errors.New("invalid version " + raw)
+		}
+		s.Pre = strings.Split(v[i+1:], ".")
+		v = v[:i]
+	}
+	parts := strings.Split(v, ".")
+	if len(parts) != 3 {
+		return s, errors.New("invalid version " + raw)
+	}
+	nums := make([]int, 3)
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 || (len(p) > 1 && p[0] == '0') {
+			return s, errors.New("invalid version " + raw)
+		}
+		nums[i] = n
+	}
+	for _, id := range s.Pre {
+		if id == "" {
+			return s, errors.New("invalid version " + raw)
+		}
+	}
+	s.Major, s.Minor, s.Patch = nums[0], nums[1], nums[2]
+	return s, nil
+}
+
+// 按照 semver 2.0 的优先级比较，a 较新时返回 1，相同时返回 0
+func compareSemver(a, b semver) int {
+	for _, d := range []int{a.Major - b.Major, a.Minor - b.Minor, a.Patch - b.Patch} {
+		if d != 0 {
+			return sign(d)
+		}
+	}
+	// 没有预发布标识的版本较新
+	if len(a.Pre) == 0 || len(b.Pre) == 0 {
+		return sign(len(b.Pre) - len(a.Pre))
+	}
+	for i := 0; i < len(a.Pre) && i < len(b.Pre); i++ {
+		x, y := a.Pre[i], b.Pre[i]
+		xn, xerr := strconv.Atoi(x)
+		yn, yerr := strconv.Atoi(y)
+		switch {
+		case xerr == nil && yerr == nil:
+			if xn != yn {
+				return sign(xn - yn)
+			}
+		case xerr == nil:
+			return -1 // 数字标识低于字母标识
+		case yerr == nil:
+			return 1
+		case x != y:
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return sign(len(a.Pre) - len(b.Pre))
+}
+
+func sign(n int) int {
+	if n > 0 {
+		return 1
+	} else if n < 0 {
+		return -1
+	}
+	return 0
+}
+
+// 发布的版本，格式与 GitHub releases 接口一致
+type releaseInfo struct {
+	TagName    string         `json:"tag_name"`
+	HTMLURL    string         `json:"html_url"`
+	Draft      bool           `json:"draft"`
+	Prerelease bool           `json:"prerelease"`
+	Assets     []releaseAsset `json:"assets"`
+}
+
+type releaseAsset struct {
+	Name string `json:"name"`
+	URL  string `json:"browser_download_url"`
+}
+
+func updateEndpoint() string {
+	if endpoint := os.Getenv(updateEndpointEnv); endpoint != "" {
+		return endpoint
+	}
+	return defaultUpdateEndpoint
+}
+
+// 更新通道，--channel 优先于环境变量
+func resolveUpdateChannel() (string, error) {
+	channel := updateChannel
+	if channel == "" {
+		channel = strings.ToLower(os.Getenv(updateChannelEnv))
+	}
+	if channel == "" {
+		return "stable", nil
+	}
+	if !containsString(updateChannels, channel) {
+		return "", errors.New("update channel must be one of " + strings.Join(updateChannels, ", "))
+	}
+	return channel, nil
+}
+
+func updateCheckDisabled() bool {
+	if noUpdateCheck {
+		return true
+	}
+	v := strings.ToLower(os.Getenv(noUpdateCheckEnv))
+	return v != "" && v != "0" && v != "false"
+}
+
+// 获取发布列表，接口也可以只返回一个版本号，视为稳定版
+func fetchReleases(endpoint string) ([]releaseInfo, error) {
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", ua)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 && resp.StatusCode <= 599 {
+		return nil, &httpStatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if err != nil {
+		return nil, err
+	}
+	var releases []releaseInfo
+	if err = json.Unmarshal(body, &releases); err == nil {
+		return releases, nil
+	}
+	var release releaseInfo
+	if err = json.Unmarshal(body, &release); err == nil && release.TagName != "" {
+		return []releaseInfo{release}, nil
+	}
+	text := strings.TrimSpace(string(body))
+	if _, err = parseSemver(text); err != nil {
+		return nil, errors.New("unexpected response from " + endpoint)
+	}
+	return []releaseInfo{{TagName: text}}, nil
+}
+
+// 选出 channel 中最新的版本，stable 忽略预发布版本
+func latestRelease(releases []releaseInfo, channel string) (releaseInfo, semver, bool) {
+	var (
+		latest    releaseInfo
+		latestVer semver
+		found     bool
+	)
+	for _, r := range releases {
+		if r.Draft {
+			continue
+		}
+		v, err := parseSemver(r.TagName)
+		if err != nil {
+			continue
+		}
+		if channel != "beta" && (r.Prerelease || len(v.Pre) > 0) {
+			continue
+		}
+		if !found || compareSemver(v, latestVer) > 0 {
+			latest, latestVer, found = r, v, true
+		}
+	}
+	return latest, latestVer, found
+}
+
+// 检查 channel 中是否有比当前版本新的版本
+func checkUpdate(channel string) (releaseInfo, bool, error) {
+	current, err := parseSemver(version)
+	if err != nil {
+		return releaseInfo{}, false, err
+	}
+	releases, err := fetchReleases(updateEndpoint())
+	if err != nil {
+		return releaseInfo{}, false, err
+	}
+	latest, latestVer, found := latestRelease(releases, channel)
+	if !found {
+		return releaseInfo{}, false, errors.New("no " + channel + " release found")
+	}
+	if latest.HTMLURL == "" {
+		latest.HTMLURL = releasePageURL + latest.TagName
+	}
+	return latest, compareSemver(latestVer, current) > 0, nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseSemver(t *testing.T) {
+	for _, v := range []string{"1.2", "1.2.3.4", "01.2.3", "1.2.3-", "1.2.3-beta..1", "v1.x.3", ""} {
+		if _, err := parseSemver(v); err == nil {
+			t.Errorf("parseSemver(%q) succeeded, want error", v)
+		}
+	}
+	s, err := parseSemver("v1.10.0-beta.2+build.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Major != 1 || s.Minor != 10 || s.Patch != 0 || len(s.Pre) != 2 || s.Pre[0] != "beta" || s.Pre[1] != "2" {
+		t.Fatalf("got %+v", s)
+	}
+}
+
+func TestCompareSemver(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"0.10.0", "0.9.9", 1},
+		{"1.0.0", "v1.0.0", 0},
+		{"1.0.0-beta.2", "1.0.0-beta.11", -1},
+		{"1.0.0-rc.1", "1.0.0", -1},
+		{"1.0.0-alpha", "1.0.0-alpha.1", -1},
+		{"1.0.0-alpha.1", "1.0.0-alpha.beta", -1},
+		{"1.0.0-beta", "1.0.0-alpha", 1},
+		{"1.0.0+build.1", "1.0.0+build.2", 0},
+		{"2.0.0", "10.0.0", -1},
+	}
+	for _, tt := range tests {
+		a, err := parseSemver(tt.a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := parseSemver(tt.b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := compareSemver(a, b); got != tt.want {
+			t.Errorf("compareSemver(%s, %s) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := compareSemver(b, a); got != -tt.want {
+			t.Errorf("compareSemver(%s, %s) = %d, want %d", tt.b, tt.a, got, -tt.want)
+		}
+	}
+}
+
+func TestLatestReleaseChannel(t *testing.T) {
+	releases := []releaseInfo{
+		{TagName: "v0.9.9"},
+		{TagName: "v0.10.0"},
+		{TagName: "v0.11.0-beta.2", Prerelease: true},
+		{TagName: "v0.11.0-beta.11"}, // 预发布版本即使未标记 prerelease 也不属于 stable
+		{TagName: "v0.12.0", Draft: true},
+		{TagName: "nightly"},
+	}
+	tests := []struct {
+		channel, want string
+	}{
+		{"stable", "v0.10.0"},
+		{"beta", "v0.11.0-beta.11"},
+	}
+	for _, tt := range tests {
+		got, _, found := latestRelease(releases, tt.channel)
+		if !found || got.TagName != tt.want {
+			t.Errorf("latestRelease(%s) = %q, %v, want %q", tt.channel, got.TagName, found, tt.want)
+		}
+	}
+	if _, _, found := latestRelease(releases[2:3], "stable"); found {
+		t.Error("stable channel picked a prerelease")
+	}
+}
+
+func TestCheckUpdateEndpoint(t *testing.T) {
+	tests := []struct {
+		name, body, channel string
+		status              int
+		wantTag             string
+		wantNewer, wantErr  bool
+	}{
+		{"github releases", `[{"tag_name":"v1.2.0"},{"tag_name":"v1.3.0-beta.1","prerelease":true}]`, "stable", 200, "v1.2.0", true, false},
+		{"beta channel", `[{"tag_name":"v1.2.0"},{"tag_name":"v1.3.0-beta.1","prerelease":true}]`, "beta", 200, "v1.3.0-beta.1", true, false},
+		{"single release", `{"tag_name":"v1.0.0","html_url":"https://mirror.example.com/v1.0.0"}`, "stable", 200, "v1.0.0", false, false},
+		{"plain version", "v1.1.1\n", "stable", 200, "v1.1.1", true, false},
+		{"server error", "", "stable", 503, "", false, true},
+		{"garbage", "<html></html>", "stable", 200, "", false, true},
+	}
+	savedVersion := version
+	version = "1.1.0"
+	defer func() { version = savedVersion }()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("User-Agent") != ua {
+					t.Errorf("User-Agent %q", r.Header.Get("User-Agent"))
+				}
+				w.WriteHeader(tt.status)
+				io.WriteString(w, tt.body)
+			}))
+			defer server.Close()
+			t.Setenv(updateEndpointEnv, server.URL)
+			release, newer, err := checkUpdate(tt.channel)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %q, want error", release.TagName)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if release.TagName != tt.wantTag || newer != tt.wantNewer {
+				t.Fatalf("got %q newer=%v, want %q newer=%v", release.TagName, newer, tt.wantTag, tt.wantNewer)
+			}
+			if release.HTMLURL == "" {
+				t.Fatal("release page URL is empty")
+			}
+		})
+	}
+}